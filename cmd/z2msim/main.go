@@ -0,0 +1,56 @@
+package main
+
+import (
+	"Panong/iot/transport"
+	"Panong/iot/z2msim"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// z2msim pretends to be zigbee2mqtt with the devices from a scenario file,
+// so the server can be run and demoed without real relays:
+//
+//	go run ./cmd/z2msim -scenario cmd/z2msim/scenario.example.yaml
+func main() {
+	scenarioPath := flag.String("scenario", "cmd/z2msim/scenario.example.yaml", "scenario file")
+	broker := flag.String("broker", "", "broker URL, overrides the scenario")
+	flag.Parse()
+
+	sc, err := z2msim.LoadScenario(*scenarioPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *broker != "" {
+		sc.Broker = *broker
+	}
+	if sc.Broker == "" {
+		sc.Broker = "tcp://localhost:1883"
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(sc.Broker)
+	opts.SetClientID(sc.ClientID)
+	opts.SetUsername(sc.Username)
+	opts.SetPassword(sc.Password)
+	opts.SetAutoReconnect(true)
+	// zigbee2mqtt ตายก็ต้องประกาศ offline เหมือนของจริง
+	opts.SetWill(sc.BaseTopic+"/bridge/state", `{"state":"offline"}`, 0, true)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatalln(token.Error())
+	}
+	defer client.Disconnect(250)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := z2msim.New(transport.NewPaho(client), sc).Run(ctx); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,222 @@
+package command
+
+import (
+	"Panong/iot/events"
+	"Panong/pkg/response"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// at most this many commands in one batch
+const maxBatch = 50
+
+// Outcome is what happened to one command of a batch
+type Outcome struct {
+	Type    string `json:"type,omitempty"`
+	Device  string `json:"device"`
+	State   string `json:"state"`
+	Success bool   `json:"success"`
+	// Confirmed is the state the device reported after the command, empty
+	// when it didn't report within the confirm timeout
+	Confirmed string          `json:"confirmed_state,omitempty"`
+	Error     *response.Error `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	Results   []Outcome `json:"results"`
+}
+
+// Batch runs many commands together and reports each one, so one device
+// timing out doesn't fail the rest. OFF commands go out through a bounded
+// pool of workers; several ON commands go through the Sequencer so they
+// are still staggered.
+type Batch struct {
+	Dispatcher *Dispatcher
+	Sequencer  *Sequencer
+	Hub        *events.Hub
+	// Workers bounds how many commands are published at once
+	Workers func() int
+	// ConfirmTimeout is how long to wait for the devices to report their
+	// state once every command is sent
+	ConfirmTimeout func() time.Duration
+}
+
+type report struct {
+	state string
+	at    time.Time
+}
+
+func (b *Batch) Run(ctx context.Context, cmds []Command) (BatchResult, error) {
+	switch {
+	case len(cmds) == 0:
+		return BatchResult{}, response.NewError(http.StatusBadRequest, response.CodeBadRequest, "commands required")
+	case len(cmds) > maxBatch:
+		return BatchResult{}, response.NewError(http.StatusBadRequest, response.CodeBadRequest,
+			fmt.Sprintf("at most %d commands at once, got %d", maxBatch, len(cmds)))
+	}
+
+	// ฟังก่อนสั่ง จะได้ไม่พลาดสถานะที่ตอบกลับเร็ว
+	_, live, stop := b.Hub.Listen(math.MaxUint64)
+	defer stop()
+	var (
+		mu       sync.Mutex
+		reported = map[string][]report{}
+	)
+	go func() {
+		for event := range live {
+			state := eventState(event)
+			if event.Type != events.TypeState || state == "" {
+				continue
+			}
+			mu.Lock()
+			reported[event.Device] = append(reported[event.Device], report{state, event.Time})
+			mu.Unlock()
+		}
+	}()
+
+	results := make([]Outcome, len(cmds))
+	sent := make([]time.Time, len(cmds))
+	var ons, rest []int
+	for i, cmd := range cmds {
+		valid, _, err := b.Dispatcher.Validate(cmd)
+		results[i] = Outcome{Type: valid.Type, Device: valid.Device, State: valid.State}
+		if err != nil {
+			results[i].Error = response.From(err)
+			continue
+		}
+		cmds[i] = valid
+		if valid.State == "OFF" {
+			rest = append(rest, i)
+		} else {
+			ons = append(ons, i)
+		}
+	}
+	// ดวงเดียวไม่ต้องเว้นระยะ
+	if len(ons) < 2 || b.Sequencer == nil {
+		rest = append(rest, ons...)
+		ons = nil
+	}
+
+	var wg sync.WaitGroup
+	if len(ons) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.stagger(ctx, cmds, ons, results, sent)
+		}()
+	}
+	jobs := make(chan int)
+	for range min(max(b.Workers(), 1), len(rest)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sent[i] = time.Now()
+				_, err := b.Dispatcher.Execute(ctx, cmds[i])
+				results[i].Success = err == nil
+				if err != nil {
+					results[i].Error = response.From(err)
+				}
+			}
+		}()
+	}
+	for _, i := range rest {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// รอให้อุปกรณ์รายงานสถานะ แต่ไม่เกิน ConfirmTimeout
+	deadline := time.NewTimer(b.ConfirmTimeout())
+	defer deadline.Stop()
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	for waiting := true; waiting; {
+		mu.Lock()
+		confirmed := true
+		for i := range results {
+			if !results[i].Success {
+				continue
+			}
+			results[i].Confirmed = lastReport(reported[results[i].Device], sent[i])
+			if results[i].Confirmed == "" || (cmds[i].State != "TOGGLE" && results[i].Confirmed != cmds[i].State) {
+				confirmed = false
+			}
+		}
+		mu.Unlock()
+		if confirmed {
+			break
+		}
+		select {
+		case <-poll.C:
+		case <-deadline.C:
+			waiting = false
+		case <-ctx.Done():
+			waiting = false
+		}
+	}
+
+	result := BatchResult{Results: results}
+	for _, outcome := range results {
+		if outcome.Success {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	return result, nil
+}
+
+// stagger sends the ON commands at ons as one sequence
+func (b *Batch) stagger(ctx context.Context, cmds []Command, ons []int, results []Outcome, sent []time.Time) {
+	subset := make([]Command, len(ons))
+	for k, i := range ons {
+		subset[k] = cmds[i]
+		sent[i] = time.Now()
+	}
+	seq, err := b.Sequencer.Run(ctx, subset)
+	if err != nil {
+		for _, i := range ons {
+			results[i].Error = response.From(err)
+		}
+		return
+	}
+	for k, i := range ons {
+		switch step := seq.Steps[k]; step.Status {
+		case StepDone:
+			results[i].Success = true
+		case StepFailed:
+			results[i].Error = step.Error
+		default:
+			results[i].Error = response.NewError(http.StatusServiceUnavailable, response.CodeServiceUnavailable, "cancelled before it was sent")
+		}
+	}
+}
+
+// lastReport is the newest state reported at or after since
+func lastReport(reports []report, since time.Time) string {
+	for i := len(reports) - 1; i >= 0; i-- {
+		if !reports[i].at.Before(since) {
+			return reports[i].state
+		}
+	}
+	return ""
+}
+
+func eventState(event events.Event) string {
+	var p struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return ""
+	}
+	return strings.ToUpper(p.State)
+}
@@ -0,0 +1,146 @@
+package command
+
+import (
+	"Panong/iot/events"
+	"Panong/pkg/response"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrDeviceNotFound   = errors.New("device not found")
+	ErrInvalidState     = errors.New("invalid state")
+	ErrDeviceTimeout    = errors.New("timeout")
+	ErrMQTTDisconnected = errors.New("MQTT client not connected")
+	ErrMQTT             = errors.New("MQTT error")
+)
+
+func init() {
+	response.RegisterError(ErrDeviceNotFound, http.StatusNotFound, response.CodeDeviceNotFound)
+	response.RegisterError(ErrInvalidState, http.StatusBadRequest, response.CodeInvalidState)
+	response.RegisterError(ErrDeviceTimeout, http.StatusGatewayTimeout, response.CodeDeviceTimeout)
+	response.RegisterError(ErrMQTTDisconnected, http.StatusServiceUnavailable, response.CodeMQTTDisconnected)
+	response.RegisterError(ErrMQTT, http.StatusBadGateway, response.CodeMQTTError)
+}
+
+// States accepted by zigbee2mqtt for our relays and the water valve
+var States = []string{"ON", "OFF", "TOGGLE"}
+
+type Command struct {
+	// Type optionally pins the command to one device family ("light", "valve")
+	Type   string `json:"type,omitempty"`
+	Device string `json:"device"`
+	State  string `json:"state"`
+	// Force asks guards to skip the limits an admin may override. It is
+	// only set from the REST routes, never from JSON bodies.
+	Force bool `json:"-"`
+}
+
+type Result struct {
+	Type   string `json:"type"`
+	Device string `json:"device"`
+	State  string `json:"state"`
+}
+
+// Target is a device family that can be switched, e.g. light.LightHandler
+type Target interface {
+	Kind() string
+	Has(device string) bool
+	Publish(device, state string) error
+}
+
+// Guard can veto a validated command before it is published, e.g.
+// safety.Interlock. When it admits cmd it should count cmd as applied until
+// commit says whether the publish went through. ctx carries the auth.User.
+type Guard interface {
+	Admit(ctx context.Context, cmd Command) (commit func(published bool), err error)
+}
+
+// Dispatcher is the single path every device command goes through, whether
+// it comes from REST, the WebSocket or an automation.
+type Dispatcher struct {
+	targets []Target
+	guards  []Guard
+	events  *events.Hub
+}
+
+func NewDispatcher(hub *events.Hub, targets ...Target) *Dispatcher {
+	return &Dispatcher{
+		targets: targets,
+		events:  hub,
+	}
+}
+
+// Guard adds g to the checks every command has to pass. Call it before the
+// dispatcher is used.
+func (d *Dispatcher) Guard(g Guard) {
+	d.guards = append(d.guards, g)
+}
+
+// Validate normalises the state and finds the target owning the device.
+func (d *Dispatcher) Validate(cmd Command) (Command, Target, error) {
+	cmd.State = strings.ToUpper(strings.TrimSpace(cmd.State))
+	if !slices.Contains(States, cmd.State) {
+		return cmd, nil, fmt.Errorf("%w %q, expected one of %s", ErrInvalidState, cmd.State, strings.Join(States, ", "))
+	}
+
+	for _, target := range d.targets {
+		if cmd.Type != "" && cmd.Type != target.Kind() {
+			continue
+		}
+		if target.Has(cmd.Device) {
+			cmd.Type = target.Kind()
+			return cmd, target, nil
+		}
+	}
+	return cmd, nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, cmd.Device)
+}
+
+func (d *Dispatcher) Execute(ctx context.Context, cmd Command) (Result, error) {
+	cmd, target, err := d.Validate(cmd)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	var commits []func(bool)
+	for _, guard := range d.guards {
+		commit, err := guard.Admit(ctx, cmd)
+		if err != nil {
+			for _, c := range commits {
+				c(false)
+			}
+			return Result{}, err
+		}
+		commits = append(commits, commit)
+	}
+
+	err = target.Publish(cmd.Device, cmd.State)
+	for _, c := range commits {
+		c(err == nil)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	payload, _ := json.Marshal(map[string]string{"state": cmd.State})
+	d.events.Publish(events.Event{
+		Type:       events.TypeCommand,
+		DeviceType: cmd.Type,
+		Device:     cmd.Device,
+		Payload:    payload,
+	})
+
+	return Result{
+		Type:   cmd.Type,
+		Device: cmd.Device,
+		State:  cmd.State,
+	}, nil
+}
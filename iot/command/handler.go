@@ -0,0 +1,111 @@
+package command
+
+import (
+	"Panong/pkg/response"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type SequenceRequest struct {
+	Commands []Command `json:"commands"`
+}
+
+type SequenceHandler struct {
+	Sequencer *Sequencer
+}
+
+// Start serves POST /devices/sequences. It answers 202 straight away;
+// progress comes from GET /devices/sequences/{id} or "sequence" events.
+func (h SequenceHandler) Start(w http.ResponseWriter, r *http.Request) {
+	var req SequenceRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		response.BadRequest(w, r, "invalid sequence: "+err.Error())
+		return
+	}
+
+	seq, err := h.Sequencer.Start(r.Context(), req.Commands)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.JSON(w, r, http.StatusAccepted, seq)
+}
+
+func (h SequenceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	seq, err := h.Sequencer.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, seq)
+}
+
+func (h SequenceHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	seq, err := h.Sequencer.Cancel(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, seq)
+}
+
+type BatchRequest struct {
+	Commands []Command `json:"commands"`
+}
+
+type BatchHandler struct {
+	Batch *Batch
+	// Lights lists the lights PUT /light/all/{action} switches
+	Lights func() []string
+}
+
+// Commands serves POST /devices/commands. It answers 200 with a result per
+// command even when some of them failed.
+func (h BatchHandler) Commands(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		response.BadRequest(w, r, "invalid commands: "+err.Error())
+		return
+	}
+	h.run(w, r, req.Commands)
+}
+
+// AllLights serves PUT /light/all/{action}
+func (h BatchHandler) AllLights(w http.ResponseWriter, r *http.Request) {
+	action := chi.URLParam(r, "action")
+	var cmds []Command
+	for _, light := range h.Lights() {
+		cmds = append(cmds, Command{Type: "light", Device: light, State: action})
+	}
+	h.run(w, r, cmds)
+}
+
+// Targets lists the devices of a POST /devices/commands body, for throttling
+func (h BatchHandler) Targets(r *http.Request) ([]string, error) {
+	var req BatchRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, cmd := range req.Commands {
+		devices = append(devices, cmd.Device)
+	}
+	return devices, nil
+}
+
+// LightTargets lists the lights PUT /light/all/{action} switches, for
+// throttling
+func (h BatchHandler) LightTargets(*http.Request) ([]string, error) {
+	return h.Lights(), nil
+}
+
+func (h BatchHandler) run(w http.ResponseWriter, r *http.Request, cmds []Command) {
+	result, err := h.Batch.Run(r.Context(), cmds)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, result)
+}
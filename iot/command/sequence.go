@@ -0,0 +1,296 @@
+package command
+
+import (
+	"Panong/iot/events"
+	"Panong/pkg/response"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrSequenceNotFound = errors.New("sequence not found")
+
+func init() {
+	response.RegisterError(ErrSequenceNotFound, http.StatusNotFound, response.CodeNotFound)
+}
+
+const (
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepDone      = "done"
+	StepFailed    = "failed"
+	StepCancelled = "cancelled"
+
+	SequenceRunning   = "running"
+	SequenceDone      = "done"
+	SequenceCancelled = "cancelled"
+)
+
+// keep this many finished sequences around for GET
+const sequenceHistory = 50
+
+type Step struct {
+	Command
+	Status string          `json:"status"`
+	Error  *response.Error `json:"error,omitempty"`
+}
+
+// Sequence is a snapshot of a staggered run, also published on the event
+// hub as a "sequence" event after every step.
+type Sequence struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Delay      string     `json:"delay"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total"`
+	Steps      []Step     `json:"steps"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type sequence struct {
+	Sequence
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Sequencer runs several commands one device at a time, waiting Delay
+// between commands that switch something on, so contactors don't all close
+// in the same instant. OFF commands go out back to back.
+type Sequencer struct {
+	Dispatcher *Dispatcher
+	Hub        *events.Hub
+	// Delay is read when a sequence starts
+	Delay func() time.Duration
+
+	mu     sync.Mutex
+	seqs   map[string]*sequence
+	order  []string
+	closed bool
+}
+
+// Start validates every command, then runs them in the background. A
+// single invalid command rejects the whole sequence. The commands run as the
+// user on ctx but outlive it; cancel the sequence with Cancel.
+func (s *Sequencer) Start(ctx context.Context, cmds []Command) (Sequence, error) {
+	if len(cmds) == 0 {
+		return Sequence{}, response.NewError(http.StatusBadRequest, response.CodeBadRequest, "commands required")
+	}
+	for i, cmd := range cmds {
+		valid, _, err := s.Dispatcher.Validate(cmd)
+		if err != nil {
+			return Sequence{}, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+		cmds[i] = valid
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return Sequence{}, err
+	}
+	var delay time.Duration
+	if s.Delay != nil {
+		delay = s.Delay()
+	}
+
+	// ไม่ผูกกับ request ที่จบไปก่อน แต่ยังเก็บ user ไว้ให้ guard กับ audit
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	seq := &sequence{
+		Sequence: Sequence{
+			ID:        hex.EncodeToString(buf),
+			Status:    SequenceRunning,
+			Delay:     delay.String(),
+			Total:     len(cmds),
+			Steps:     make([]Step, len(cmds)),
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for i, cmd := range cmds {
+		seq.Steps[i] = Step{Command: cmd, Status: StepPending}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		return Sequence{}, response.NewError(http.StatusServiceUnavailable, response.CodeServiceUnavailable, "shutting down")
+	}
+	if s.seqs == nil {
+		s.seqs = make(map[string]*sequence)
+	}
+	s.seqs[seq.ID] = seq
+	s.order = append(s.order, seq.ID)
+	s.prune()
+	snapshot := s.snapshot(seq)
+	s.mu.Unlock()
+
+	go s.run(ctx, seq, delay)
+	return snapshot, nil
+}
+
+// Run is Start but waits for the sequence to finish or ctx to be done,
+// which cancels it.
+func (s *Sequencer) Run(ctx context.Context, cmds []Command) (Sequence, error) {
+	seq, err := s.Start(ctx, cmds)
+	if err != nil {
+		return seq, err
+	}
+	s.mu.Lock()
+	running := s.seqs[seq.ID]
+	s.mu.Unlock()
+
+	select {
+	case <-running.done:
+	case <-ctx.Done():
+		running.cancel()
+		<-running.done
+	}
+	return s.Get(seq.ID)
+}
+
+func (s *Sequencer) run(ctx context.Context, seq *sequence, delay time.Duration) {
+	defer close(seq.done)
+	defer seq.cancel()
+
+	switchedOn := false
+	for i := range seq.Steps {
+		s.mu.Lock()
+		cmd := seq.Steps[i].Command
+		s.mu.Unlock()
+
+		// ไฟดวงแรกเปิดได้เลย ดวงต่อไปค่อยเว้นระยะ
+		if cmd.State != "OFF" && switchedOn && delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		s.update(seq, func() { seq.Steps[i].Status = StepRunning })
+		_, err := s.Dispatcher.Execute(ctx, cmd)
+		s.update(seq, func() {
+			if errors.Is(err, context.Canceled) {
+				seq.Steps[i].Status = StepCancelled
+				return
+			}
+			seq.Done++
+			if err != nil {
+				seq.Steps[i].Status = StepFailed
+				seq.Steps[i].Error = response.From(err)
+				return
+			}
+			seq.Steps[i].Status = StepDone
+		})
+		if err == nil && cmd.State != "OFF" {
+			switchedOn = true
+		}
+	}
+
+	s.update(seq, func() {
+		now := time.Now()
+		seq.FinishedAt = &now
+		seq.Status = SequenceDone
+		for i := range seq.Steps {
+			if seq.Steps[i].Status == StepPending {
+				seq.Steps[i].Status = StepCancelled
+			}
+			if seq.Steps[i].Status == StepCancelled {
+				seq.Status = SequenceCancelled
+			}
+		}
+	})
+	log.Printf("[command] sequence %s %s: %d of %d commands run", seq.ID, seq.Status, seq.Done, seq.Total)
+}
+
+// update changes seq under the lock and publishes the new snapshot
+func (s *Sequencer) update(seq *sequence, fn func()) {
+	s.mu.Lock()
+	fn()
+	snapshot := s.snapshot(seq)
+	s.mu.Unlock()
+
+	payload, _ := json.Marshal(snapshot)
+	s.Hub.Publish(events.Event{
+		Type:    events.TypeSequence,
+		Device:  snapshot.ID,
+		Payload: payload,
+	})
+}
+
+func (s *Sequencer) Get(id string) (Sequence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, ok := s.seqs[id]
+	if !ok {
+		return Sequence{}, fmt.Errorf("%w: %s", ErrSequenceNotFound, id)
+	}
+	return s.snapshot(seq), nil
+}
+
+// Cancel stops a running sequence before its next command and waits for it
+// to wind down. Cancelling a finished sequence is a no-op.
+func (s *Sequencer) Cancel(id string) (Sequence, error) {
+	s.mu.Lock()
+	seq, ok := s.seqs[id]
+	s.mu.Unlock()
+	if !ok {
+		return Sequence{}, fmt.Errorf("%w: %s", ErrSequenceNotFound, id)
+	}
+	seq.cancel()
+	<-seq.done
+	return s.Get(id)
+}
+
+// Close cancels every running sequence and waits for them; for shutdown.
+func (s *Sequencer) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var running []*sequence
+	for _, seq := range s.seqs {
+		running = append(running, seq)
+	}
+	s.mu.Unlock()
+
+	for _, seq := range running {
+		seq.cancel()
+		select {
+		case <-seq.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// prune must be called with s.mu held
+func (s *Sequencer) prune() {
+	for len(s.order) > sequenceHistory {
+		oldest := s.seqs[s.order[0]]
+		if oldest.FinishedAt == nil {
+			break
+		}
+		delete(s.seqs, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// snapshot must be called with s.mu held
+func (s *Sequencer) snapshot(seq *sequence) Sequence {
+	out := seq.Sequence
+	out.Steps = append([]Step(nil), seq.Steps...)
+	return out
+}
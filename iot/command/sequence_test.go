@@ -0,0 +1,69 @@
+package command
+
+import (
+	"Panong/pkg/auth"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type relays struct {
+	mu        sync.Mutex
+	published []string
+}
+
+func (r *relays) Kind() string           { return "light" }
+func (r *relays) Has(device string) bool { return true }
+func (r *relays) Publish(device, state string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = append(r.published, device+" "+state)
+	return nil
+}
+
+// users records who each command ran as
+type users struct {
+	mu  sync.Mutex
+	saw []string
+}
+
+func (u *users) Admit(ctx context.Context, cmd Command) (func(bool), error) {
+	user, _ := auth.FromContext(ctx)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.saw = append(u.saw, user.Name)
+	return func(bool) {}, nil
+}
+
+func TestSequenceRunsAsTheCallerAfterTheRequestEnds(t *testing.T) {
+	r, u := &relays{}, &users{}
+	d := NewDispatcher(nil, r)
+	d.Guard(u)
+	s := &Sequencer{Dispatcher: d, Delay: func() time.Duration { return 20 * time.Millisecond }}
+
+	ctx, cancel := context.WithCancel(auth.WithUser(context.Background(), auth.User{ID: 7, Name: "somchai"}))
+	seq, err := s.Start(ctx, []Command{{Device: "light1", State: "on"}, {Device: "light2", State: "on"}, {Device: "light3", State: "on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// request จบแล้ว sequence ต้องเดินต่อ
+	cancel()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for seq.Status == SequenceRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		seq, _ = s.Get(seq.ID)
+	}
+	if seq.Status != SequenceDone {
+		t.Fatalf("sequence %s, want %s: %+v", seq.Status, SequenceDone, seq.Steps)
+	}
+	want := []string{"light1 ON", "light2 ON", "light3 ON"}
+	if !slices.Equal(r.published, want) {
+		t.Errorf("published %v, want %v", r.published, want)
+	}
+	if !slices.Equal(u.saw, []string{"somchai", "somchai", "somchai"}) {
+		t.Errorf("commands ran as %q, want somchai", u.saw)
+	}
+}
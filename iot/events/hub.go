@@ -0,0 +1,208 @@
+package events
+
+import (
+	"Panong/iot/transport"
+	"Panong/pkg/metrics"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeState        = "state"
+	TypeAvailability = "availability"
+	TypeCommand      = "command"
+	// TypeSequence carries a command.Sequence snapshot; Device is its ID
+	TypeSequence = "sequence"
+)
+
+// Device maps a zigbee2mqtt friendly name back to the ID used in our routes
+type Device struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type Event struct {
+	ID         uint64          `json:"id"`
+	Type       string          `json:"type"`
+	DeviceType string          `json:"device_type"`
+	Device     string          `json:"device"`
+	Name       string          `json:"name,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+	Time       time.Time       `json:"time"`
+}
+
+// Hub turns zigbee2mqtt messages into Events, keeps the last few in a ring
+// buffer for Last-Event-ID resume and fans them out to subscribers.
+type Hub struct {
+	devices func() []Device
+
+	mu     sync.Mutex
+	nextID uint64
+	buffer []Event
+	start  int
+	count  int
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func NewHub(size int, devices func() []Device) *Hub {
+	if size <= 0 {
+		size = 256
+	}
+	return &Hub{
+		devices: devices,
+		nextID:  1,
+		buffer:  make([]Event, size),
+		subs:    make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe listens to device state and availability topics. Call it from
+// the MQTT OnConnect handler so it is redone after every reconnect.
+func (h *Hub) Subscribe(client transport.Client) {
+	for _, topic := range []string{"zigbee2mqtt/+", "zigbee2mqtt/+/availability"} {
+		if err := client.Subscribe(topic, 0, h.handleMessage); err != nil {
+			log.Printf("[events] failed to subscribe %s: %v", topic, err)
+			continue
+		}
+		metrics.MQTTSubscribed(topic)
+	}
+}
+
+func (h *Hub) handleMessage(msg transport.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic, "zigbee2mqtt/"), "/")
+	if len(parts) == 0 || parts[0] == "bridge" {
+		return
+	}
+
+	var device *Device
+	for _, d := range h.devices() {
+		if d.Name == parts[0] || d.ID == parts[0] {
+			device = &d
+			break
+		}
+	}
+	if device == nil {
+		return
+	}
+
+	event := Event{
+		Type:       TypeState,
+		DeviceType: device.Type,
+		Device:     device.ID,
+		Name:       device.Name,
+	}
+	if len(parts) == 2 && parts[1] == "availability" {
+		event.Type = TypeAvailability
+	}
+
+	payload := msg.Payload
+	if json.Valid(payload) {
+		event.Payload = json.RawMessage(payload)
+	} else {
+		// availability แบบเก่าส่งมาเป็น online/offline เฉยๆ
+		event.Payload, _ = json.Marshal(map[string]string{"state": string(payload)})
+	}
+
+	if event.Type == TypeState {
+		metrics.ObserveDeviceStatus(device.Type, device.ID, event.Payload)
+	}
+	h.Publish(event)
+}
+
+// Publish assigns the next ID and delivers the event. A nil Hub is a no-op
+// so handlers work without events wired in.
+func (h *Hub) Publish(event Event) {
+	if h == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event.ID = h.nextID
+	h.nextID++
+
+	end := (h.start + h.count) % len(h.buffer)
+	h.buffer[end] = event
+	if h.count < len(h.buffer) {
+		h.count++
+	} else {
+		h.start = (h.start + 1) % len(h.buffer)
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			// ผู้รับช้าเกินไป ตัดทิ้ง ให้ client reconnect พร้อม Last-Event-ID เอง
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Listen returns the buffered events newer than lastID plus a channel of live
+// events. The channel is closed if the subscriber falls behind; call the
+// returned cancel func when done.
+func (h *Hub) Listen(lastID uint64) ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	h.mu.Lock()
+	var backlog []Event
+	for i := range h.count {
+		event := h.buffer[(h.start+i)%len(h.buffer)]
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+	if h.closed {
+		close(ch)
+	} else {
+		h.subs[ch] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// Close ends every live subscription so SSE and WebSocket handlers return
+// during shutdown. Later Listen calls get an already closed channel.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Recent returns up to n of the newest buffered events, oldest first.
+func (h *Hub) Recent(n int) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n <= 0 || n > h.count {
+		n = h.count
+	}
+	out := make([]Event, 0, n)
+	for i := h.count - n; i < h.count; i++ {
+		out = append(out, h.buffer[(h.start+i)%len(h.buffer)])
+	}
+	return out
+}
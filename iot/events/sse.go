@@ -0,0 +1,143 @@
+package events
+
+import (
+	"Panong/pkg/response"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const keepAlive = 15 * time.Second
+
+type EventsHandler struct {
+	Hub *Hub
+}
+
+// Filter matches events by device type and device ID. Empty lists match all.
+type Filter struct {
+	Types   []string `json:"types"`
+	Devices []string `json:"devices"`
+}
+
+func ParseFilter(r *http.Request) Filter {
+	split := func(key string) []string {
+		var out []string
+		for _, v := range r.URL.Query()[key] {
+			for _, part := range strings.Split(v, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					out = append(out, part)
+				}
+			}
+		}
+		return out
+	}
+	return Filter{
+		Types:   split("type"),
+		Devices: split("device"),
+	}
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.DeviceType) {
+		return false
+	}
+	if len(f.Devices) > 0 && !slices.Contains(f.Devices, e.Device) {
+		return false
+	}
+	return true
+}
+
+// Stream serves GET /events as Server-Sent Events.
+//
+//	?type=light,valve   only these device types
+//	?device=<id>        only these devices
+//
+// Reconnecting clients send Last-Event-ID (or ?last_event_id=) and get the
+// buffered events they missed first.
+func (h EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	filter := ParseFilter(r)
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	since, _ := strconv.ParseUint(lastID, 10, 64)
+
+	backlog, live, cancel := h.Hub.Listen(since)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// ไม่มี Last-Event-ID แปลว่าเพิ่งเปิด ส่งแค่ของใหม่
+	if lastID != "" {
+		for _, event := range backlog {
+			if filter.Match(event) {
+				writeEvent(w, event)
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-live:
+			if !ok {
+				return
+			}
+			if !filter.Match(event) {
+				continue
+			}
+			writeEvent(w, event)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Recent serves GET /events/recent?limit=50 as JSON, honouring the same
+// type/device filters as Stream.
+func (h EventsHandler) Recent(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	filter := ParseFilter(r)
+
+	recent := []Event{}
+	for _, event := range h.Hub.Recent(0) {
+		if filter.Match(event) {
+			recent = append(recent, event)
+		}
+	}
+	if len(recent) > limit {
+		recent = recent[len(recent)-limit:]
+	}
+
+	response.OK(w, r, recent)
+}
+
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
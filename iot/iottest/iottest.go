@@ -0,0 +1,103 @@
+// Package iottest has the fixtures shared by the device handler tests: a
+// config, a zigbee2mqtt stand-in on transport.FakeBroker and a way to call a
+// handler and read the response envelope.
+package iottest
+
+import (
+	"Panong/iot/events"
+	"Panong/iot/transport"
+	"Panong/pkg/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Env is the smallest .env that loads: four lights and the water valve
+const Env = `BROKER=localhost
+HEADER_SECRET_AUTH=secret
+FIRST_LIGHT=light1
+SECOND_LIGHT=light2
+THIRD_LIGHT=light3
+IN_FRONT_OF_CLUBHOUSE_LOGO_LIGHT=logo
+WATER_VALVE=water
+`
+
+// Config loads Env plus any extra KEY=value lines
+func Config(t testing.TB, extra ...string) *config.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.env")
+	env := Env + strings.Join(extra, "\n")
+	if err := os.WriteFile(path, []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	store, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// Zigbee2MQTT answers like zigbee2mqtt for devices: a message on
+// zigbee2mqtt/<id>/get or /set gets the device's state back on
+// zigbee2mqtt/<friendly name>, along with fields. A /set changes the state
+// first. Devices missing from states never answer.
+func Zigbee2MQTT(t testing.TB, broker *transport.FakeBroker, devices []events.Device, states map[string]string, fields map[string]any) {
+	t.Helper()
+	client := broker.Client()
+	names := map[string]string{}
+	for _, d := range devices {
+		names[d.ID] = d.Name
+	}
+	var mu sync.Mutex
+	err := client.Subscribe("zigbee2mqtt/+/+", 0, func(msg transport.Message) {
+		parts := strings.Split(msg.Topic, "/")
+		id, verb := parts[1], parts[2]
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := states[id]; !ok {
+			return
+		}
+		if verb == "set" {
+			var p struct {
+				State string `json:"state"`
+			}
+			json.Unmarshal(msg.Payload, &p)
+			states[id] = p.State
+		}
+		payload := map[string]any{"state": states[id]}
+		for k, v := range fields {
+			payload[k] = v
+		}
+		body, _ := json.Marshal(payload)
+		client.Publish("zigbee2mqtt/"+names[id], 0, false, body)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Envelope is the response.JSON body
+type Envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+// Do serves one request with no body and decodes the envelope
+func Do(t testing.TB, h http.Handler, method, path string) (int, Envelope) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	var body Envelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: %v in %q", method, path, err, rec.Body.String())
+	}
+	return rec.Code, body
+}
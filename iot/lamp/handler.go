@@ -0,0 +1,72 @@
+package lamp
+
+import (
+	"Panong/iot/command"
+	"Panong/pkg/auth"
+	"Panong/pkg/response"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler struct {
+	Tracker *Tracker
+}
+
+func (h Handler) light(w http.ResponseWriter, r *http.Request) (string, bool) {
+	light := chi.URLParam(r, "light")
+	if !h.Tracker.Has(light) {
+		response.Fail(w, r, fmt.Errorf("%w: %s", command.ErrDeviceNotFound, light))
+		return "", false
+	}
+	return light, true
+}
+
+// Maintenance is GET /light/{light}/maintenance
+func (h Handler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	light, ok := h.light(w, r)
+	if !ok {
+		return
+	}
+	status := h.Tracker.Status(light)
+	replacements, err := h.Tracker.Store.Replacements(r.Context(), light, 20)
+	switch {
+	case errors.Is(err, ErrNoDatabase):
+		replacements = []Replacement{}
+	case err != nil:
+		response.Fail(w, r, err)
+		return
+	}
+	status.Replacements = replacements
+	response.OK(w, r, status)
+}
+
+// Replace is POST /light/{light}/maintenance/replacements, staff recording
+// a new lamp
+func (h Handler) Replace(w http.ResponseWriter, r *http.Request) {
+	light, ok := h.light(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	// body ว่างได้ ไม่ต้องมี note
+	if r.ContentLength != 0 {
+		if err := render.DecodeJSON(r.Body, &body); err != nil {
+			response.BadRequest(w, r, "invalid replacement: "+err.Error())
+			return
+		}
+	}
+
+	user, _ := auth.FromContext(r.Context())
+	replacement, err := h.Tracker.Replace(r.Context(), light, user, body.Note)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.JSON(w, r, http.StatusCreated, replacement)
+}
@@ -0,0 +1,143 @@
+package lamp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNoDatabase = errors.New("lamp history is not available without a database")
+
+// Counter is what is kept per light in lamp_hours
+type Counter struct {
+	Device string
+	Burned time.Duration
+	// Reminded is the last reminder sent for this lamp, see reminderLevel
+	Reminded    int
+	InstalledAt *time.Time
+}
+
+// Replacement is one lamp change recorded by staff
+type Replacement struct {
+	ID        int64     `json:"id"`
+	Device    string    `json:"device"`
+	BurnHours float64   `json:"burn_hours"`
+	UserID    int64     `json:"user_id"`
+	User      string    `json:"user"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps counters and replacements in Postgres. A nil DB makes every
+// call fail with ErrNoDatabase.
+type Store struct {
+	DB *pgxpool.Pool
+}
+
+func (s Store) Load(ctx context.Context) ([]Counter, error) {
+	if s.DB == nil {
+		return nil, ErrNoDatabase
+	}
+	rows, err := s.DB.Query(ctx, `SELECT device, burn_seconds, reminded, installed_at FROM lamp_hours;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counters []Counter
+	for rows.Next() {
+		var (
+			c       Counter
+			seconds int64
+		)
+		if err := rows.Scan(&c.Device, &seconds, &c.Reminded, &c.InstalledAt); err != nil {
+			return nil, err
+		}
+		c.Burned = time.Duration(seconds) * time.Second
+		counters = append(counters, c)
+	}
+	return counters, rows.Err()
+}
+
+const upsertCounter = `
+    INSERT INTO lamp_hours (device, burn_seconds, reminded, installed_at, updated_at)
+    VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+    ON CONFLICT (device) DO UPDATE
+    SET burn_seconds = EXCLUDED.burn_seconds,
+        reminded = EXCLUDED.reminded,
+        installed_at = EXCLUDED.installed_at,
+        updated_at = CURRENT_TIMESTAMP;
+    `
+
+func (s Store) Save(ctx context.Context, counters []Counter) error {
+	if s.DB == nil {
+		return ErrNoDatabase
+	}
+	batch := &pgx.Batch{}
+	for _, c := range counters {
+		batch.Queue(upsertCounter, c.Device, int64(c.Burned/time.Second), c.Reminded, c.InstalledAt)
+	}
+	return s.DB.SendBatch(ctx, batch).Close()
+}
+
+// Replace logs the replacement and saves the reset counter together
+func (s Store) Replace(ctx context.Context, r Replacement, reset Counter) (Replacement, error) {
+	if s.DB == nil {
+		return r, ErrNoDatabase
+	}
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return r, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+    INSERT INTO lamp_replacements (device, burn_seconds, user_id, user_name, note)
+    VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+    RETURNING id, created_at;
+    `
+	seconds := int64(r.BurnHours * 3600)
+	if err := tx.QueryRow(ctx, query, r.Device, seconds, r.UserID, r.User, r.Note).Scan(&r.ID, &r.CreatedAt); err != nil {
+		return r, err
+	}
+	if _, err := tx.Exec(ctx, upsertCounter, reset.Device, int64(reset.Burned/time.Second), reset.Reminded, reset.InstalledAt); err != nil {
+		return r, err
+	}
+	return r, tx.Commit(ctx)
+}
+
+// Replacements returns the newest replacements of a light first
+func (s Store) Replacements(ctx context.Context, device string, limit int) ([]Replacement, error) {
+	if s.DB == nil {
+		return nil, ErrNoDatabase
+	}
+	query := `
+    SELECT id, device, burn_seconds, user_id, user_name, COALESCE(note, ''), created_at
+    FROM lamp_replacements
+    WHERE device = $1
+    ORDER BY id DESC
+    LIMIT $2;
+    `
+	rows, err := s.DB.Query(ctx, query, device, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replacements := []Replacement{}
+	for rows.Next() {
+		var (
+			r       Replacement
+			seconds int64
+		)
+		if err := rows.Scan(&r.ID, &r.Device, &seconds, &r.UserID, &r.User, &r.Note, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.BurnHours = hours(time.Duration(seconds) * time.Second)
+		replacements = append(replacements, r)
+	}
+	return replacements, rows.Err()
+}
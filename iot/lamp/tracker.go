@@ -0,0 +1,338 @@
+package lamp
+
+import (
+	"Panong/iot/events"
+	"Panong/pkg/auth"
+	"Panong/pkg/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reminder levels, stored so a restart doesn't send them again
+const (
+	remindNone = iota
+	remindSoon
+	remindDue
+)
+
+// hours of lamps that are still on are saved this often
+const checkpoint = time.Minute
+
+// Status is GET /light/{light}/maintenance
+type Status struct {
+	Device      string  `json:"device"`
+	On          bool    `json:"on"`
+	BurnHours   float64 `json:"burn_hours"`
+	RatedHours  float64 `json:"rated_hours,omitempty"`
+	LifePercent float64 `json:"life_percent,omitempty"`
+	// Reminder is "soon" past LAMP_REMINDER_PERCENT and "due" past the
+	// rated life
+	Reminder     string        `json:"reminder,omitempty"`
+	InstalledAt  *time.Time    `json:"installed_at,omitempty"`
+	Replacements []Replacement `json:"replacements"`
+}
+
+// Tracker adds up how long each light has been on from the state events and
+// reminds on Discord as a lamp nears its rated life. Hours are kept in
+// memory and saved to the Store every minute.
+type Tracker struct {
+	Config *config.Store
+	Hub    *events.Hub
+	Store  Store
+	// Notify sends the maintenance reminders
+	Notify func(title, description string)
+
+	mu    sync.Mutex
+	lamps map[string]*lamp
+}
+
+type lamp struct {
+	Counter
+	// onSince is when the hours still to be added started, zero while off
+	onSince time.Time
+	dirty   bool
+}
+
+// get must be called with t.mu held
+func (t *Tracker) get(device string) *lamp {
+	if t.lamps == nil {
+		t.lamps = make(map[string]*lamp)
+	}
+	l, ok := t.lamps[device]
+	if !ok {
+		l = &lamp{Counter: Counter{Device: device}}
+		t.lamps[device] = l
+	}
+	return l
+}
+
+func (t *Tracker) Has(light string) bool {
+	return slices.Contains(t.Config.Get().Lights.IDs(), light)
+}
+
+// Run loads the saved hours, then counts until ctx is done and saves once
+// more.
+func (t *Tracker) Run(ctx context.Context) {
+	counters, err := t.Store.Load(ctx)
+	switch {
+	case errors.Is(err, ErrNoDatabase):
+		log.Println("[lamp] no database, lamp hours are not kept across restarts")
+	case err != nil:
+		log.Println("[lamp] failed to load lamp hours:", err)
+	}
+	t.mu.Lock()
+	for _, c := range counters {
+		t.get(c.Device).Counter = c
+	}
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(checkpoint)
+	defer ticker.Stop()
+	defer func() {
+		// ctx ถูก cancel แล้ว แต่ยังต้องเก็บชั่วโมงล่าสุด
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		t.flush(saveCtx, time.Now())
+	}()
+
+	var lastID uint64
+	for {
+		backlog, ch, cancel := t.Hub.Listen(lastID)
+		for _, event := range backlog {
+			t.observe(event)
+			lastID = event.ID
+		}
+
+		open := true
+		for open {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case now := <-ticker.C:
+				t.flush(ctx, now)
+			case event, ok := <-ch:
+				if !ok {
+					open = false
+					break
+				}
+				t.observe(event)
+				lastID = event.ID
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (t *Tracker) observe(event events.Event) {
+	if event.Type != events.TypeState || !t.Has(event.Device) {
+		return
+	}
+	var p struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.get(event.Device)
+	switch strings.ToUpper(p.State) {
+	case "ON":
+		if l.onSince.IsZero() {
+			l.onSince = event.Time
+		}
+	case "OFF":
+		if !l.onSince.IsZero() {
+			l.add(event.Time)
+			l.onSince = time.Time{}
+		}
+	}
+}
+
+// add counts the hours on up to now
+func (l *lamp) add(now time.Time) {
+	if d := now.Sub(l.onSince); d > 0 {
+		l.Burned += d
+		l.onSince = now
+		l.dirty = true
+	}
+}
+
+type reminder struct {
+	device string
+	level  int
+	hours  float64
+	rated  float64
+}
+
+// flush adds the hours of lamps that are on, sends due reminders and saves
+// what changed
+func (t *Tracker) flush(ctx context.Context, now time.Time) {
+	cfg := t.Config.Get()
+	rated := cfg.Lamps.Rated()
+
+	t.mu.Lock()
+	var (
+		changed []Counter
+		due     []reminder
+	)
+	for device, l := range t.lamps {
+		if !l.onSince.IsZero() {
+			l.add(now)
+		}
+		level := reminderLevel(l.Burned, rated[device], cfg.Lamps.ReminderPercent)
+		if level > l.Reminded {
+			due = append(due, reminder{device, level, hours(l.Burned), rated[device]})
+			l.dirty = true
+		}
+		l.Reminded = level
+		if l.dirty {
+			changed = append(changed, l.Counter)
+			l.dirty = false
+		}
+	}
+	t.mu.Unlock()
+
+	for _, r := range due {
+		t.remind(r)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if err := t.Store.Save(ctx, changed); err != nil && !errors.Is(err, ErrNoDatabase) {
+		log.Println("[lamp] failed to save lamp hours:", err)
+		// ลองใหม่รอบหน้า
+		t.mu.Lock()
+		for _, c := range changed {
+			t.get(c.Device).dirty = true
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tracker) remind(r reminder) {
+	var description string
+	switch r.level {
+	case remindDue:
+		description = fmt.Sprintf("%s has burned %gh, past its rated %gh. Replace the lamp.", r.device, r.hours, r.rated)
+	default:
+		description = fmt.Sprintf("%s has burned %gh of its rated %gh (%g%%). Plan a lamp replacement.",
+			r.device, r.hours, r.rated, percent(r.hours, r.rated))
+	}
+	log.Println("[lamp]", description)
+	if t.Notify != nil {
+		t.Notify("Lamp maintenance", description)
+	}
+}
+
+// Status reports the hours of a light so far; Replacements is left for the
+// caller.
+func (t *Tracker) Status(device string) Status {
+	cfg := t.Config.Get()
+	rated := cfg.Lamps.Rated()[device]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.get(device)
+	burned := l.Burned
+	if !l.onSince.IsZero() {
+		burned += time.Since(l.onSince)
+	}
+
+	status := Status{
+		Device:      device,
+		On:          !l.onSince.IsZero(),
+		BurnHours:   hours(burned),
+		RatedHours:  rated,
+		InstalledAt: l.InstalledAt,
+	}
+	if rated > 0 {
+		status.LifePercent = percent(status.BurnHours, rated)
+	}
+	switch reminderLevel(burned, rated, cfg.Lamps.ReminderPercent) {
+	case remindSoon:
+		status.Reminder = "soon"
+	case remindDue:
+		status.Reminder = "due"
+	}
+	return status
+}
+
+// Replace records a new lamp in device and starts its count from zero. The
+// database isn't written under t.mu, so events keep flowing meanwhile.
+func (t *Tracker) Replace(ctx context.Context, device string, user auth.User, note string) (Replacement, error) {
+	t.mu.Lock()
+	l := t.get(device)
+	now := time.Now()
+	if !l.onSince.IsZero() {
+		l.add(now)
+	}
+	burned := l.Burned
+	t.mu.Unlock()
+
+	r := Replacement{
+		Device:    device,
+		BurnHours: hours(burned),
+		UserID:    user.ID,
+		User:      user.Name,
+		Note:      note,
+		CreatedAt: now,
+	}
+	reset := Counter{Device: device, InstalledAt: &now}
+	r, err := t.Store.Replace(ctx, r, reset)
+	if err != nil && !errors.Is(err, ErrNoDatabase) {
+		return r, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l = t.get(device)
+	if !l.onSince.IsZero() {
+		l.add(time.Now())
+	}
+	// ชั่วโมงที่เพิ่มระหว่างเขียน DB เป็นของหลอดใหม่
+	reset.Burned = max(l.Burned-burned, 0)
+	l.Counter = reset
+	// flush อาจเขียนค่าเก่าทับไประหว่างนั้น ให้ flush รอบหน้าเขียนใหม่
+	l.dirty = true
+	log.Printf("[lamp] %s replaced by %s after %gh", device, user.Name, r.BurnHours)
+	return r, nil
+}
+
+func reminderLevel(burned time.Duration, rated, reminderPercent float64) int {
+	switch h := burned.Hours(); {
+	case rated <= 0:
+		return remindNone
+	case h >= rated:
+		return remindDue
+	case reminderPercent > 0 && h >= rated*reminderPercent/100:
+		return remindSoon
+	default:
+		return remindNone
+	}
+}
+
+// hours rounds to a tenth of an hour
+func hours(d time.Duration) float64 {
+	return math.Round(d.Hours()*10) / 10
+}
+
+func percent(h, rated float64) float64 {
+	return math.Round(h/rated*1000) / 10
+}
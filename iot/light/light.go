@@ -1,40 +1,50 @@
 package light
 
 import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/iot/transport"
+	"Panong/pkg/config"
+	"Panong/pkg/metrics"
+	"Panong/pkg/response"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/go-chi/chi/v5"
-	"github.com/spf13/viper"
 )
 
 type LightHandler struct {
-	MqttClient mqtt.Client
+	MqttClient transport.Client
+	Commands   *command.Dispatcher
+	Config     *config.Store
+	// StatusTimeout is how long a device gets to answer a status request,
+	// 30s when zero
+	StatusTimeout time.Duration
+}
+
+func (l LightHandler) statusTimeout() time.Duration {
+	if l.StatusTimeout > 0 {
+		return l.StatusTimeout
+	}
+	return 30 * time.Second
 }
 
 type Payload struct {
 	State string `json:"state"`
 }
 
-var ErrFriendlyNameNotFound = errors.New("friendly name not found.")
+var ErrFriendlyNameNotFound = fmt.Errorf("friendly name not found: %w", command.ErrDeviceNotFound)
 
 func (l LightHandler) Lights() []string {
-	return []string{
-		viper.GetString("FIRST_LIGHT"),
-		viper.GetString("SECOND_LIGHT"),
-		viper.GetString("THIRD_LIGHT"),
-		// viper.GetString("FOURTH_LIGHT"),
-		viper.GetString("IN_FRONT_OF_CLUBHOUSE_LOGO_LIGHT"),
-		// viper.GetString("TRIPLE_PLUGS"),
-	}
+	return l.Config.Get().Lights.IDs()
 }
 
 func (l LightHandler) getFriendlyName(light string) (string, error) {
@@ -55,7 +65,20 @@ func (l LightHandler) getFriendlyName(light string) (string, error) {
 	}
 }
 
-func (l LightHandler) getZigbee2MQTTLightStatus(client mqtt.Client, light string) (string, error) {
+// Devices lists the configured lights with their zigbee2mqtt friendly names
+func (l LightHandler) Devices() []events.Device {
+	var devices []events.Device
+	for _, light := range l.Lights() {
+		name, err := l.getFriendlyName(light)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, events.Device{ID: light, Type: "light", Name: name})
+	}
+	return devices
+}
+
+func (l LightHandler) getZigbee2MQTTLightStatus(client transport.Client, light string) (string, error) {
 	// 1. retry connection MQTT
 	connected := false
 	for range 3 {
@@ -67,7 +90,7 @@ func (l LightHandler) getZigbee2MQTTLightStatus(client mqtt.Client, light string
 		time.Sleep(2 * time.Second)
 	}
 	if !connected {
-		return "", fmt.Errorf("MQTT client not connected after retries")
+		return "", fmt.Errorf("%w after retries", command.ErrMQTTDisconnected)
 	}
 
 	// 2. แปลงชื่อ friendly name
@@ -79,94 +102,96 @@ func (l LightHandler) getZigbee2MQTTLightStatus(client mqtt.Client, light string
 	subscribedTopic := fmt.Sprintf("zigbee2mqtt/%s", friendlyName)
 	getTopic := fmt.Sprintf("zigbee2mqtt/%s/get", light)
 
-	// 3. สร้าง channel สำหรับรับสถานะ
-	statusCh := make(chan string, 1)
-
-	// 4. Subscribe topic ของ light
-	token := client.Subscribe(subscribedTopic, 0, func(client mqtt.Client, msg mqtt.Message) {
-		statusCh <- string(msg.Payload())
-	})
-	token.Wait()
-	if err := token.Error(); err != nil {
-		return "", fmt.Errorf("failed to subscribe: %w", err)
-	}
-
-	// 5. Publish request สถานะ
+	// 3. Publish request สถานะแล้วรอ response (request พร้อมกันใช้ subscription เดียวกัน)
 	payload, _ := json.Marshal(map[string]string{"state": ""})
-	pubToken := client.Publish(getTopic, 0, false, payload)
-	pubToken.Wait()
-	if err := pubToken.Error(); err != nil {
-		client.Unsubscribe(subscribedTopic)
-		return "", fmt.Errorf("failed to publish get request: %w", err)
-	}
-
-	// 6. รอ response จาก channel
-	select {
-	case status := <-statusCh:
-		unsubToken := client.Unsubscribe(subscribedTopic)
-		unsubToken.Wait()
-		return status, nil
-	case <-time.After(30 * time.Second):
-		unsubToken := client.Unsubscribe(subscribedTopic)
-		unsubToken.Wait()
-		return "", errors.New("timeout waiting for light status")
+	sentAt := time.Now()
+	msg, err := transport.Request(client, subscribedTopic, getTopic, payload, l.statusTimeout())
+	switch {
+	case errors.Is(err, transport.ErrNoReply):
+		metrics.StatusTimeout(light)
+		return "", fmt.Errorf("%w waiting for light status", command.ErrDeviceTimeout)
+	case err != nil:
+		return "", fmt.Errorf("%w: %w", command.ErrMQTT, err)
 	}
+	metrics.MQTTSubscribed(subscribedTopic)
+	metrics.MQTTPublished(getTopic)
+	metrics.StatusRoundTrip(light, time.Since(sentAt))
+	metrics.ObserveDeviceStatus("light", light, msg.Payload)
+	return string(msg.Payload), nil
 }
 
-func (l LightHandler) updateZigbee2MQTTLight(client mqtt.Client, action, light string) error {
+func (l LightHandler) updateZigbee2MQTTLight(client transport.Client, action, light string) error {
 	payload, err := json.Marshal(Payload{State: action})
 	if err != nil {
 		return err
 	}
 
 	if !slices.Contains(l.Lights(), light) {
-		return errors.New("light not found")
+		return fmt.Errorf("light %w", command.ErrDeviceNotFound)
+	}
+	if !client.IsConnected() {
+		return command.ErrMQTTDisconnected
 	}
 
-	token := client.Publish(fmt.Sprintf("zigbee2mqtt/%s/set", light), 0, false, string(payload))
-	token.Wait()
+	setTopic := fmt.Sprintf("zigbee2mqtt/%s/set", light)
+	if err := client.Publish(setTopic, 0, false, payload); err != nil {
+		return fmt.Errorf("failed to publish set request: %w: %w", command.ErrMQTT, err)
+	}
+	metrics.MQTTPublished(setTopic)
 	time.Sleep(time.Second)
 
 	return nil
 }
 
+func (l LightHandler) Kind() string {
+	return "light"
+}
+
+func (l LightHandler) Has(light string) bool {
+	return slices.Contains(l.Lights(), light)
+}
+
+// Publish sends the state to zigbee2mqtt; it is called by command.Dispatcher
+func (l LightHandler) Publish(light, state string) error {
+	return l.updateZigbee2MQTTLight(l.MqttClient, state, light)
+}
+
 func (l LightHandler) UpdateLight(w http.ResponseWriter, r *http.Request) {
 	light := chi.URLParam(r, "light")
 	action := chi.URLParam(r, "action")
+	// ?force=true ให้ admin ข้าม re-strike protection ได้ (มี audit)
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
 
-	err := l.updateZigbee2MQTTLight(l.MqttClient, action, light)
+	result, err := l.Commands.Execute(r.Context(), command.Command{Type: "light", Device: light, State: action, Force: force})
 	if err != nil {
-		http.Error(w, "Failed to publish message", http.StatusInternalServerError)
+		response.Fail(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Light updated successfully"))
+	response.OK(w, r, result)
 }
 
 func (l LightHandler) Light(w http.ResponseWriter, r *http.Request) {
 	light := chi.URLParam(r, "light")
 	if light == "" {
-		http.Error(w, "light param required", http.StatusBadRequest)
+		response.BadRequest(w, r, "light param required")
 		return
 	}
 
 	status, err := l.getZigbee2MQTTLightStatus(l.MqttClient, light)
 	if err != nil {
-		if errors.Is(err, ErrFriendlyNameNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Fail(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(status))
+	if !json.Valid([]byte(status)) {
+		response.Fail(w, r, response.NewError(http.StatusBadGateway, response.CodeInvalidDeviceData, "invalid light data"))
+		return
+	}
+	response.OK(w, r, json.RawMessage(status))
 }
 
-func (l LightHandler) getZigbee2MQTTLightStatuses(client mqtt.Client) (map[string]string, error) {
+func (l LightHandler) getZigbee2MQTTLightStatuses(client transport.Client) (map[string]string, error) {
 	lights := l.Lights()
 	results := make(map[string]string)
 	var mu sync.Mutex
@@ -192,11 +217,18 @@ func (l LightHandler) getZigbee2MQTTLightStatuses(client mqtt.Client) (map[strin
 	close(errCh)
 
 	if len(errCh) > 0 {
+		var first error
 		var errs []string
 		for e := range errCh {
+			if first == nil {
+				first = e
+			}
 			errs = append(errs, e.Error())
 		}
-		return results, fmt.Errorf("some lights failed: %s", strings.Join(errs, "; "))
+		// ใช้ code ของ error แรก (ส่วนใหญ่คือ timeout) แล้วแนบรายละเอียดทุกดวง
+		failed := response.From(first).WithDetails(errs)
+		failed.Message = "some lights failed: " + strings.Join(errs, "; ")
+		return results, failed
 	}
 
 	return results, nil
@@ -211,32 +243,24 @@ type LightStatus struct {
 func (l LightHandler) GetAllLights(w http.ResponseWriter, r *http.Request) {
 	rawStatuses, err := l.getZigbee2MQTTLightStatuses(l.MqttClient)
 	if err != nil {
-		if errors.Is(err, ErrFriendlyNameNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Fail(w, r, err)
 		return
 	}
 
 	// แปลงค่า string JSON ให้เป็น object พร้อมเพิ่ม id
-	var statuses []LightStatus
+	statuses := []LightStatus{}
 	for k, v := range rawStatuses {
 		var s LightStatus
 		if err := json.Unmarshal([]byte(v), &s); err != nil {
-			http.Error(w, "invalid light data: "+err.Error(), http.StatusInternalServerError)
+			response.Fail(w, r, response.NewError(http.StatusBadGateway, response.CodeInvalidDeviceData, "invalid light data: "+err.Error()))
 			return
 		}
 		s.ID = k
 		statuses = append(statuses, s)
 	}
+	slices.SortFunc(statuses, func(a, b LightStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
 
-	w.Header().Set("Content-Type", "application/json")
-	payload, err := json.MarshalIndent(statuses, "", "  ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(payload)
+	response.OK(w, r, statuses)
 }
@@ -0,0 +1,123 @@
+package light
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/iottest"
+	"Panong/iot/transport"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// setup returns the light routes on a fake broker. The devices only answer
+// once respond is called.
+func setup(t *testing.T) (http.Handler, *transport.FakeBroker, func(states map[string]string)) {
+	t.Helper()
+	broker := transport.NewFakeBroker()
+	t.Cleanup(broker.Close)
+
+	l := LightHandler{MqttClient: broker.Client(), Config: iottest.Config(t), StatusTimeout: 200 * time.Millisecond}
+	l.Commands = command.NewDispatcher(nil, l)
+
+	r := chi.NewRouter()
+	r.Get("/lights", l.GetAllLights)
+	r.Get("/{light}", l.Light)
+	r.Put("/{light}/{action}", l.UpdateLight)
+
+	respond := func(states map[string]string) {
+		iottest.Zigbee2MQTT(t, broker, l.Devices(), states, map[string]any{"linkquality": 100})
+	}
+	return r, broker, respond
+}
+
+func TestLightTimeout(t *testing.T) {
+	h, _, _ := setup(t)
+
+	for _, path := range []string{"/light1", "/lights"} {
+		code, body := iottest.Do(t, h, http.MethodGet, path)
+		if code != http.StatusGatewayTimeout || body.Error == nil || body.Error.Code != "DEVICE_TIMEOUT" {
+			t.Errorf("GET %s = %d %+v, want 504 DEVICE_TIMEOUT", path, code, body.Error)
+		}
+	}
+}
+
+func TestGetAllLights(t *testing.T) {
+	h, _, respond := setup(t)
+	respond(map[string]string{"light1": "ON", "light2": "OFF", "light3": "OFF", "logo": "ON"})
+
+	code, body := iottest.Do(t, h, http.MethodGet, "/lights")
+	if code != http.StatusOK {
+		t.Fatalf("GET /lights = %d %+v", code, body.Error)
+	}
+	var statuses []LightStatus
+	json.Unmarshal(body.Data, &statuses)
+	want := []LightStatus{{"light1", 100, "ON"}, {"light2", 100, "OFF"}, {"light3", 100, "OFF"}, {"logo", 100, "ON"}}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %+v, want %+v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("statuses[%d] = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestConcurrentRequestsToOneLight(t *testing.T) {
+	h, broker, respond := setup(t)
+	respond(map[string]string{"light1": "ON"})
+
+	const n = 5
+	var wg sync.WaitGroup
+	codes := make([]int, 2*n)
+	states := make([]string, n)
+	for i := range n {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			code, body := iottest.Do(t, h, http.MethodGet, "/light1")
+			var p Payload
+			json.Unmarshal(body.Data, &p)
+			codes[i], states[i] = code, p.State
+		}()
+		go func() {
+			defer wg.Done()
+			codes[n+i], _ = iottest.Do(t, h, http.MethodPut, "/light1/on")
+		}()
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d = %d, want 200", i, code)
+		}
+	}
+	for i, state := range states {
+		if state != "ON" {
+			t.Errorf("GET %d state = %q, want ON", i, state)
+		}
+	}
+	if sets := broker.Published("zigbee2mqtt/light1/set"); len(sets) != n {
+		t.Errorf("published %d set requests, want %d", len(sets), n)
+	}
+}
+
+func TestUnknownLight(t *testing.T) {
+	h, broker, _ := setup(t)
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/nope"},
+		{http.MethodPut, "/nope/ON"},
+	} {
+		code, body := iottest.Do(t, h, req.method, req.path)
+		if code != http.StatusNotFound || body.Error == nil || body.Error.Code != "DEVICE_NOT_FOUND" {
+			t.Errorf("%s %s = %d %+v, want 404 DEVICE_NOT_FOUND", req.method, req.path, code, body.Error)
+		}
+	}
+	if sets := broker.Published("zigbee2mqtt/+/set"); len(sets) != 0 {
+		t.Errorf("published %v for an unknown light", sets)
+	}
+}
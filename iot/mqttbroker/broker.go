@@ -0,0 +1,108 @@
+package mqttbroker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// Broker is an in-process MQTT broker for venues that only run a Zigbee
+// coordinator and this server; zigbee2mqtt connects to it like any broker.
+type Broker struct {
+	server *mqtt.Server
+	opts   Options
+}
+
+type Options struct {
+	// Addr is the TCP listen address, e.g. ":1883"
+	Addr string
+	// WSAddr optionally adds a WebSocket listener, e.g. ":1882"
+	WSAddr string
+	// Ledger holds the users and ACLs. Clients not in it are refused, and
+	// topics no user or ledger ACL rule allows are denied.
+	Ledger *auth.Ledger
+}
+
+// LoadLedger reads a mochi-mqtt auth ledger (YAML or JSON):
+//
+//	users:
+//	  zigbee2mqtt:
+//	    password: secret
+//	    acl:
+//	      "zigbee2mqtt/#": 3
+//
+// An empty path gives an empty ledger, so only users added in code connect.
+func LoadLedger(path string) (*auth.Ledger, error) {
+	ledger := &auth.Ledger{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_EMBEDDED_AUTH: %w", err)
+		}
+		if err := ledger.Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("MQTT_EMBEDDED_AUTH: %w", err)
+		}
+	}
+	if ledger.Users == nil {
+		ledger.Users = auth.Users{}
+	}
+	return ledger, nil
+}
+
+// AllowAll gives username full read/write access, used for our own client.
+func AllowAll(ledger *auth.Ledger, username, password string) {
+	ledger.Users[username] = auth.UserRule{
+		Username: auth.RString(username),
+		Password: auth.RString(password),
+		ACL:      auth.Filters{"#": auth.ReadWrite},
+	}
+}
+
+func New(opts Options) (*Broker, error) {
+	if opts.Ledger == nil {
+		return nil, errors.New("mqttbroker: a ledger is required")
+	}
+
+	// mochi อนุญาต topic ที่ไม่ตรง ACL ไหนเลย ปิดไว้เป็นกฎสุดท้าย
+	opts.Ledger.ACL = append(opts.Ledger.ACL, auth.ACLRule{Filters: auth.Filters{"#": auth.Deny}})
+
+	server := mqtt.New(&mqtt.Options{
+		InlineClient: false,
+		Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := server.AddHook(new(auth.Hook), &auth.Options{Ledger: opts.Ledger}); err != nil {
+		return nil, err
+	}
+
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: opts.Addr})); err != nil {
+		return nil, err
+	}
+	if opts.WSAddr != "" {
+		if err := server.AddListener(listeners.NewWebsocket(listeners.Config{ID: "ws", Address: opts.WSAddr})); err != nil {
+			return nil, err
+		}
+	}
+	return &Broker{server: server, opts: opts}, nil
+}
+
+// Start binds the listeners and serves in the background.
+func (b *Broker) Start() error {
+	if err := b.server.Serve(); err != nil {
+		return err
+	}
+	log.Printf("[broker] embedded MQTT broker listening on %s", b.opts.Addr)
+	if b.opts.WSAddr != "" {
+		log.Printf("[broker] embedded MQTT broker WebSocket on %s", b.opts.WSAddr)
+	}
+	return nil
+}
+
+func (b *Broker) Close() error {
+	return b.server.Close()
+}
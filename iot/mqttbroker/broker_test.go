@@ -0,0 +1,139 @@
+package mqttbroker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+)
+
+const ledgerYAML = `users:
+  zigbee2mqtt:
+    password: z2m-secret
+    acl:
+      "zigbee2mqtt/#": 3
+  noacl:
+    password: secret
+`
+
+// start runs the broker on a free port with our own user "panong" and the
+// ledger above.
+func start(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ledger := &auth.Ledger{}
+	if err := ledger.Unmarshal([]byte(ledgerYAML)); err != nil {
+		t.Fatal(err)
+	}
+	AllowAll(ledger, "panong", "panong-secret")
+	broker, err := New(Options{Addr: addr, Ledger: ledger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return addr
+}
+
+func connect(addr, username, password string) (paho.Client, error) {
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID(username).
+		SetUsername(username).
+		SetPassword(password).
+		SetConnectRetry(false).
+		SetAutoReconnect(false)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		return nil, paho.ErrNotConnected
+	}
+	return client, token.Error()
+}
+
+func TestLogin(t *testing.T) {
+	addr := start(t)
+
+	for _, c := range []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"panong", "panong-secret", true},
+		{"zigbee2mqtt", "z2m-secret", true},
+		{"zigbee2mqtt", "wrong", false},
+		{"stranger", "secret", false},
+		{"", "", false},
+	} {
+		client, err := connect(addr, c.username, c.password)
+		if (err == nil) != c.ok {
+			t.Errorf("connect as %q/%q: err = %v, want ok = %v", c.username, c.password, err, c.ok)
+		}
+		if err == nil {
+			client.Disconnect(0)
+		}
+	}
+}
+
+func TestACLDeniesUnlistedTopics(t *testing.T) {
+	addr := start(t)
+
+	server, err := connect(addr, "panong", "panong-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Disconnect(0)
+	received := make(chan string, 10)
+	token := server.Subscribe("#", 0, func(_ paho.Client, msg paho.Message) { received <- msg.Topic() })
+	token.WaitTimeout(5 * time.Second)
+	if err := token.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	z2m, err := connect(addr, "zigbee2mqtt", "z2m-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z2m.Disconnect(0)
+
+	sub := z2m.Subscribe("other/#", 1, func(paho.Client, paho.Message) {}).(*paho.SubscribeToken)
+	sub.WaitTimeout(5 * time.Second)
+	if code := sub.Result()["other/#"]; code != 0x80 {
+		t.Errorf("subscribe outside the ACL = %#x, want 0x80", code)
+	}
+
+	// QoS 0 publishes that are denied are dropped silently, so the allowed one
+	// goes last and must be the only one that arrives
+	for _, topic := range []string{"other/x", "panong/status", "zigbee2mqtt/light1/set"} {
+		z2m.Publish(topic, 0, false, "{}").WaitTimeout(5 * time.Second)
+	}
+	select {
+	case topic := <-received:
+		if topic != "zigbee2mqtt/light1/set" {
+			t.Errorf("got a publish on %q, outside the ACL", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("allowed publish never arrived")
+	}
+
+	noACL, err := connect(addr, "noacl", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noACL.Disconnect(0)
+	noACL.Publish("zigbee2mqtt/light1/set", 0, false, "{}").WaitTimeout(5 * time.Second)
+	select {
+	case topic := <-received:
+		t.Errorf("user without an ACL published on %q", topic)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
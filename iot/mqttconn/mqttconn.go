@@ -0,0 +1,223 @@
+package mqttconn
+
+import (
+	"Panong/iot/command"
+	"Panong/pkg/config"
+	"Panong/pkg/metrics"
+	"Panong/pkg/response"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Options builds the paho options for cfg. Brokers are added in order, and
+// paho moves on to the next one when a connection attempt fails.
+func Options(cfg config.MQTTConfig) (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions()
+	for _, broker := range cfg.URLs() {
+		opts.AddBroker(broker)
+	}
+	// Connect ไม่ retry เอง ให้ Monitor.Connect backoff แทน
+	opts.SetConnectRetry(false)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetMaxReconnectInterval(time.Minute)
+	opts.SetClientID(cfg.ClientID)
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
+
+// TLSConfig loads the CA bundle and client certificate from cfg. It returns
+// nil when nothing TLS-related is configured, so ssl:// and wss:// brokers
+// fall back to the system roots.
+func TLSConfig(cfg config.MQTTConfig) (*tls.Config, error) {
+	if cfg.CACert == "" && cfg.ClientCert == "" && !cfg.TLSInsecure {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.TLSInsecure,
+	}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CA_CERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("MQTT_CA_CERT: no PEM certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CLIENT_CERT/MQTT_CLIENT_KEY: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+type Status struct {
+	Connected bool `json:"connected"`
+	// Broker is the URL currently connected to, or last tried
+	Broker    string    `json:"broker,omitempty"`
+	Brokers   []string  `json:"brokers"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+	Connects  int       `json:"connects"`
+}
+
+// Monitor keeps track of the MQTT connection for /health and metrics, and
+// reports losing and regaining it through Notify.
+type Monitor struct {
+	// Notify is optional and runs in its own goroutine
+	Notify func(title, description string)
+
+	mu     sync.Mutex
+	status Status
+	down   bool
+}
+
+func NewMonitor(brokers []string) *Monitor {
+	return &Monitor{
+		status: Status{
+			Brokers: brokers,
+			Since:   time.Now(),
+		},
+	}
+}
+
+// Attach wraps the connect handlers already set on opts, so call it last.
+func (m *Monitor) Attach(opts *mqtt.ClientOptions) {
+	onConnect := opts.OnConnect
+	onLost := opts.OnConnectionLost
+	onAttempt := opts.OnConnectAttempt
+
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		m.mu.Lock()
+		m.status.Broker = broker.String()
+		m.mu.Unlock()
+		if onAttempt != nil {
+			return onAttempt(broker, tlsCfg)
+		}
+		return tlsCfg
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		m.mu.Lock()
+		downtime := time.Since(m.status.Since)
+		wasDown := m.down
+		m.down = false
+		m.status.Connected = true
+		m.status.Since = time.Now()
+		m.status.LastError = ""
+		m.status.Connects++
+		broker := m.status.Broker
+		m.mu.Unlock()
+		metrics.MQTTConnected(broker)
+		if wasDown {
+			m.notify("MQTT connection restored", fmt.Sprintf("Connected to %s after %s", broker, downtime.Round(time.Second)))
+		}
+		if onConnect != nil {
+			onConnect(client)
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		m.mu.Lock()
+		m.status.Connected = false
+		m.status.Since = time.Now()
+		m.status.LastError = err.Error()
+		m.down = true
+		broker := m.status.Broker
+		m.mu.Unlock()
+		metrics.MQTTDisconnected()
+		m.notify("MQTT connection lost", fmt.Sprintf("Lost %s: %v\nDevice commands fail with 503 until it reconnects.", broker, err))
+		if onLost != nil {
+			onLost(client, err)
+		}
+	})
+}
+
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *Monitor) Connected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status.Connected
+}
+
+// Connect dials until the first connection succeeds or ctx ends, doubling
+// the wait between attempts up to maxDelay. paho's auto-reconnect takes over
+// from there, so the API can start while the broker is still down.
+func (m *Monitor) Connect(ctx context.Context, client mqtt.Client, minDelay, maxDelay time.Duration) {
+	delay := minDelay
+	for attempt := 1; ; attempt++ {
+		token := client.Connect()
+		token.Wait()
+		err := token.Error()
+		if err == nil {
+			return
+		}
+
+		m.mu.Lock()
+		m.status.LastError = err.Error()
+		first := !m.down
+		m.down = true
+		m.mu.Unlock()
+		log.Printf("[MQTT] connect attempt %d failed, retrying in %s: %v", attempt, delay, err)
+		if first {
+			m.notify("MQTT broker unreachable", fmt.Sprintf("Can't connect to %s: %v\nRetrying in the background.", strings.Join(m.status.Brokers, ", "), err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// Require answers 503 MQTT_DISCONNECTED while there's no broker connection,
+// instead of letting device requests wait for one.
+func (m *Monitor) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Connected() {
+			w.Header().Set("Retry-After", "5")
+			response.Fail(w, r, command.ErrMQTTDisconnected)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Monitor) notify(title, description string) {
+	if m.Notify != nil {
+		go m.Notify(title, description)
+	}
+}
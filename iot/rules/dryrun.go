@@ -0,0 +1,139 @@
+package rules
+
+import (
+	"Panong/iot/events"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type DryRunRequest struct {
+	// RuleID tests a stored rule, otherwise Rule is tested as given
+	RuleID int64 `json:"rule_id,omitempty"`
+	Rule   *Rule `json:"rule,omitempty"`
+	// Event simulates an incoming event. Without it state triggers are
+	// checked against the current states.
+	Event *Input `json:"event,omitempty"`
+	// States override the current device states, as if held long enough
+	// for any "for"
+	States map[string]string `json:"states,omitempty"`
+	// At overrides the current time
+	At *time.Time `json:"at,omitempty"`
+}
+
+type DryRunResult struct {
+	Rule   Rule              `json:"rule"`
+	Fires  bool              `json:"fires"`
+	Reason string            `json:"reason"`
+	States map[string]string `json:"states"`
+	// Steps are the actions that would run; nothing is published
+	Steps []string `json:"steps"`
+}
+
+// DryRun reports whether rule would fire for req and what it would do.
+// rule must already be validated.
+func (e *Engine) DryRun(rule Rule, req DryRunRequest) DryRunResult {
+	now := time.Now()
+	if req.At != nil {
+		now = req.At.Local()
+	}
+
+	states := e.States()
+	held := map[string]bool{}
+	for device, state := range req.States {
+		states[device] = strings.ToUpper(state)
+		held[device] = true
+	}
+	e.mu.Lock()
+	since := make(map[string]time.Time, len(e.states))
+	for device, st := range e.states {
+		since[device] = st.Since
+	}
+	e.mu.Unlock()
+
+	result := DryRunResult{Rule: rule, Steps: []string{}}
+	result.Fires, result.Reason = dryRunTrigger(rule.Trigger, req.Event, states, func(device string) bool {
+		return held[device] || now.Sub(since[device]) >= time.Duration(rule.Trigger.For)
+	}, now)
+	if req.Event != nil && (req.Event.Type == "" || req.Event.Type == events.TypeState) && req.Event.State != "" {
+		states[req.Event.Device] = strings.ToUpper(req.Event.State)
+	}
+	result.States = states
+
+	if result.Fires {
+		if ok, why := checkConditions(rule.Conditions, now, func(device string) string { return states[device] }); !ok {
+			result.Fires, result.Reason = false, "conditions: "+why
+		}
+	}
+	if !result.Fires {
+		return result
+	}
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case ActionSet:
+			result.Steps = append(result.Steps, fmt.Sprintf("set %s %s", action.Device, action.State))
+		case ActionNotify:
+			result.Steps = append(result.Steps, "notify "+action.Message)
+		case ActionDelay:
+			step := "wait " + time.Duration(action.Delay).String()
+			if len(rule.Conditions) > 0 {
+				step += " and check conditions again"
+			}
+			result.Steps = append(result.Steps, step)
+		}
+	}
+	return result
+}
+
+func dryRunTrigger(t Trigger, event *Input, states map[string]string, heldFor func(device string) bool, now time.Time) (bool, string) {
+	switch t.Type {
+	case TriggerTime:
+		if now.Format("15:04") != t.At {
+			return false, fmt.Sprintf("fires daily at %s, it is %s", t.At, now.Format("15:04"))
+		}
+		return true, "it is " + t.At
+	case TriggerEvent:
+		if event == nil {
+			return false, "needs an event"
+		}
+		in := *event
+		in.State = strings.ToUpper(in.State)
+		if !eventMatches(t, in) {
+			return false, fmt.Sprintf("%s event from %s doesn't match", in.Type, in.Device)
+		}
+		return true, fmt.Sprintf("%s event from %s", in.Type, in.Device)
+	}
+
+	if event != nil {
+		in := *event
+		if in.Type == "" {
+			in.Type = events.TypeState
+		}
+		in.State = strings.ToUpper(in.State)
+		if !stateMatches(t, in) {
+			return false, fmt.Sprintf("%s turning %s doesn't match", in.Device, in.State)
+		}
+		if states[in.Device] == in.State {
+			return false, fmt.Sprintf("%s is already %s", in.Device, in.State)
+		}
+		if t.For > 0 {
+			return true, fmt.Sprintf("%s turned %s, fires if it stays %s for %s", in.Device, in.State, in.State, time.Duration(t.For))
+		}
+		return true, fmt.Sprintf("%s turned %s", in.Device, in.State)
+	}
+
+	devices := slices.Clone(t.Devices)
+	slices.Sort(devices)
+	for _, device := range devices {
+		if states[device] != t.State {
+			continue
+		}
+		if t.For > 0 && !heldFor(device) {
+			return false, fmt.Sprintf("%s is %s but not for %s yet", device, t.State, time.Duration(t.For))
+		}
+		return true, fmt.Sprintf("%s is %s", device, t.State)
+	}
+	return false, fmt.Sprintf("none of %s is %s", strings.Join(devices, ", "), t.State)
+}
@@ -0,0 +1,123 @@
+package rules
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	at := func(clock string) *time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		now := time.Date(2026, 10, 19, parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+		return &now
+	}
+	porch := Rule{
+		Name:    "porch",
+		Trigger: Trigger{Type: TriggerState, Devices: []string{"light1"}, State: "ON", For: Duration(10 * time.Minute)},
+		Conditions: []Condition{
+			{After: "18:00", Before: "06:00"},
+		},
+		Actions: []Action{
+			{Type: ActionSet, Device: "light2", State: "ON"},
+			{Type: ActionDelay, Delay: Duration(30 * time.Minute)},
+			{Type: ActionSet, Device: "light2", State: "OFF"},
+		},
+	}
+	morning := Rule{
+		Name:    "morning",
+		Trigger: Trigger{Type: TriggerTime, At: "06:30"},
+		Actions: []Action{{Type: ActionNotify, Message: "good morning"}},
+	}
+	offline := Rule{
+		Name:    "offline",
+		Trigger: Trigger{Type: TriggerEvent, Event: "availability", Devices: []string{"water"}},
+		Actions: []Action{{Type: ActionNotify, Message: "valve offline"}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		rule   Rule
+		req    DryRunRequest
+		fires  bool
+		reason string
+		steps  []string
+	}{
+		{
+			name:   "time trigger",
+			rule:   morning,
+			req:    DryRunRequest{At: at("06:30")},
+			fires:  true,
+			reason: "it is 06:30",
+			steps:  []string{"notify good morning"},
+		},
+		{
+			name:   "time trigger at another time",
+			rule:   morning,
+			req:    DryRunRequest{At: at("07:00")},
+			reason: "fires daily at 06:30, it is 07:00",
+		},
+		{
+			name:   "state held long enough",
+			rule:   porch,
+			req:    DryRunRequest{States: map[string]string{"light1": "on"}, At: at("22:00")},
+			fires:  true,
+			reason: "light1 is ON",
+			steps:  []string{"set light2 ON", "wait 30m0s and check conditions again", "set light2 OFF"},
+		},
+		{
+			name:   "state event",
+			rule:   porch,
+			req:    DryRunRequest{Event: &Input{Device: "light1", State: "on"}, States: map[string]string{"light1": "OFF"}, At: at("22:00")},
+			fires:  true,
+			reason: "light1 turned ON, fires if it stays ON for 10m0s",
+			steps:  []string{"set light2 ON", "wait 30m0s and check conditions again", "set light2 OFF"},
+		},
+		{
+			name:   "state event that changes nothing",
+			rule:   porch,
+			req:    DryRunRequest{Event: &Input{Device: "light1", State: "ON"}, States: map[string]string{"light1": "ON"}, At: at("22:00")},
+			reason: "light1 is already ON",
+		},
+		{
+			name:   "outside the window",
+			rule:   porch,
+			req:    DryRunRequest{States: map[string]string{"light1": "ON"}, At: at("12:00")},
+			reason: "conditions: 12:00 is outside 18:00-06:00",
+		},
+		{
+			name:   "state not reached",
+			rule:   porch,
+			req:    DryRunRequest{States: map[string]string{"light1": "OFF"}, At: at("22:00")},
+			reason: "none of light1 is ON",
+		},
+		{
+			name:   "event trigger without an event",
+			rule:   offline,
+			reason: "needs an event",
+		},
+		{
+			name:   "event trigger",
+			rule:   offline,
+			req:    DryRunRequest{Event: &Input{Type: "availability", Device: "water"}},
+			fires:  true,
+			reason: "availability event from water",
+			steps:  []string{"notify valve offline"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Engine{}
+			got := e.DryRun(tc.rule, tc.req)
+			if got.Fires != tc.fires || !strings.Contains(got.Reason, tc.reason) {
+				t.Errorf("fires %v %q, want %v %q", got.Fires, got.Reason, tc.fires, tc.reason)
+			}
+			if tc.steps == nil {
+				tc.steps = []string{}
+			}
+			if !slices.Equal(got.Steps, tc.steps) {
+				t.Errorf("steps %q, want %q", got.Steps, tc.steps)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package rules
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Executor is what set actions go through, normally command.Dispatcher
+type Executor interface {
+	Execute(ctx context.Context, cmd command.Command) (command.Result, error)
+}
+
+// Engine evaluates the enabled rules against the live event stream and a
+// one second clock. A rule that is still running (e.g. in a delay) is not
+// fired again until it finishes.
+type Engine struct {
+	Store    Store
+	Hub      *events.Hub
+	Commands Executor
+	Devices  func() []events.Device
+	// Notify delivers notify actions, e.g. to Discord; nil only logs them
+	Notify func(title, description string)
+
+	mu      sync.Mutex
+	ctx     context.Context
+	rules   []Rule
+	states  map[string]deviceState
+	held    map[holdKey]bool
+	daily   map[int64]string
+	running map[int64]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type deviceState struct {
+	State string
+	Since time.Time
+}
+
+// holdKey marks a state-for rule as fired for the current hold of a device
+type holdKey struct {
+	rule   int64
+	device string
+}
+
+// Input is an event reduced to what triggers compare
+type Input struct {
+	Type   string `json:"type"`
+	Device string `json:"device"`
+	State  string `json:"state"`
+}
+
+// Reload reads the rules again; call it after they change. Runs of rules
+// that were deleted or disabled are cancelled.
+func (e *Engine) Reload(ctx context.Context) error {
+	all, err := e.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	e.load(all)
+	return nil
+}
+
+// load swaps in the enabled rules. Rules saved before Validate refused ones
+// that trigger themselves are skipped, they would fire forever.
+func (e *Engine) load(all []Rule) {
+	enabled := slices.DeleteFunc(all, func(r Rule) bool {
+		if !r.Enabled {
+			return true
+		}
+		if i := r.selfTriggering(); i >= 0 {
+			log.Printf("[rules] %q triggers itself with actions[%d], not running it", r.Name, i)
+			return true
+		}
+		return false
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = enabled
+	for id, cancel := range e.running {
+		if !slices.ContainsFunc(enabled, func(r Rule) bool { return r.ID == id }) {
+			cancel()
+		}
+	}
+}
+
+// Run evaluates rules until ctx is done, then waits for running actions to
+// stop.
+func (e *Engine) Run(ctx context.Context) {
+	e.mu.Lock()
+	e.ctx = ctx
+	e.states = make(map[string]deviceState)
+	e.held = make(map[holdKey]bool)
+	e.daily = make(map[int64]string)
+	e.running = make(map[int64]context.CancelFunc)
+	e.mu.Unlock()
+	defer e.wg.Wait()
+
+	if err := e.Reload(ctx); err != nil {
+		if errors.Is(err, ErrNoDatabase) {
+			log.Println("[rules] no database, automations disabled")
+			return
+		}
+		log.Println("[rules] failed to load rules:", err)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastID uint64
+	first := true
+	for {
+		backlog, ch, cancel := e.Hub.Listen(lastID)
+		for _, event := range backlog {
+			// ตอนเริ่มแค่จำสถานะล่าสุด ไม่ยิง rule จาก event เก่า
+			e.handle(event, !first)
+			lastID = event.ID
+		}
+		first = false
+
+		open := true
+		for open {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case now := <-ticker.C:
+				e.tick(now)
+			case event, ok := <-ch:
+				if !ok {
+					open = false
+					break
+				}
+				e.handle(event, true)
+				lastID = event.ID
+			}
+		}
+		cancel()
+
+		// หลุดเพราะตามไม่ทัน (หรือ hub กำลังปิด) รอนิดแล้ว listen ใหม่
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (e *Engine) handle(event events.Event, fire bool) {
+	t := Input{Type: event.Type, Device: event.Device, State: payloadState(event.Payload)}
+	now := time.Now()
+
+	e.mu.Lock()
+	var due []firing
+	if t.Type == events.TypeState && t.State != "" {
+		prev, known := e.states[t.Device]
+		if !known || prev.State != t.State {
+			e.states[t.Device] = deviceState{State: t.State, Since: event.Time}
+			for key := range e.held {
+				if key.device == t.Device {
+					delete(e.held, key)
+				}
+			}
+			if known {
+				for _, rule := range e.rules {
+					if rule.Trigger.Type == TriggerState && rule.Trigger.For == 0 && stateMatches(rule.Trigger, t) {
+						due = append(due, firing{rule, fmt.Sprintf("%s turned %s", t.Device, t.State)})
+					}
+				}
+			}
+		}
+	}
+	for _, rule := range e.rules {
+		if rule.Trigger.Type == TriggerEvent && eventMatches(rule.Trigger, t) {
+			due = append(due, firing{rule, fmt.Sprintf("%s event from %s", t.Type, t.Device)})
+		}
+	}
+	e.mu.Unlock()
+
+	if fire {
+		for _, f := range due {
+			e.fire(f.rule, f.reason, now)
+		}
+	}
+}
+
+func (e *Engine) tick(now time.Time) {
+	today := now.Format(time.DateOnly)
+
+	e.mu.Lock()
+	var due []firing
+	for _, rule := range e.rules {
+		t := rule.Trigger
+		switch {
+		case t.Type == TriggerTime && now.Format("15:04") == t.At && e.daily[rule.ID] != today:
+			e.daily[rule.ID] = today
+			due = append(due, firing{rule, "it is " + t.At})
+		case t.Type == TriggerState && t.For > 0:
+			for _, device := range t.Devices {
+				key := holdKey{rule.ID, device}
+				st, ok := e.states[device]
+				if !ok || st.State != t.State || e.held[key] || now.Sub(st.Since) < time.Duration(t.For) {
+					continue
+				}
+				e.held[key] = true
+				due = append(due, firing{rule, fmt.Sprintf("%s has been %s for %s", device, t.State, time.Duration(t.For))})
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, f := range due {
+		e.fire(f.rule, f.reason, now)
+	}
+}
+
+type firing struct {
+	rule   Rule
+	reason string
+}
+
+func (e *Engine) fire(rule Rule, reason string, now time.Time) {
+	e.mu.Lock()
+	if _, busy := e.running[rule.ID]; busy {
+		e.mu.Unlock()
+		log.Printf("[rules] %q is still running, ignoring %s", rule.Name, reason)
+		return
+	}
+	if ok, _ := checkConditions(rule.Conditions, now, e.stateLocked); !ok {
+		e.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(e.ctx)
+	e.running[rule.ID] = cancel
+	e.wg.Add(1)
+	e.mu.Unlock()
+
+	log.Printf("[rules] %q fired: %s", rule.Name, reason)
+	go func() {
+		defer e.wg.Done()
+		run := e.execute(ctx, rule, reason, now)
+		cancel()
+
+		e.mu.Lock()
+		delete(e.running, rule.ID)
+		e.mu.Unlock()
+
+		log.Printf("[rules] %q %s: %s", rule.Name, run.Status, strings.Join(run.Steps, ", "))
+		// ctx ของ run อาจโดน cancel ไปแล้ว แต่ยังอยากเก็บ log
+		logCtx, stop := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer stop()
+		if err := e.Store.LogRun(logCtx, run); err != nil {
+			log.Printf("[rules] failed to log run of %q: %v", rule.Name, err)
+		}
+	}()
+}
+
+func (e *Engine) execute(ctx context.Context, rule Rule, reason string, started time.Time) Run {
+	run := Run{
+		RuleID:    rule.ID,
+		Trigger:   reason,
+		Status:    RunDone,
+		Steps:     []string{},
+		StartedAt: started,
+	}
+	defer func() { run.FinishedAt = time.Now() }()
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case ActionSet:
+			step := fmt.Sprintf("set %s %s", action.Device, action.State)
+			if _, err := e.Commands.Execute(ctx, command.Command{Device: action.Device, State: action.State}); err != nil {
+				run.Steps = append(run.Steps, step+" failed")
+				run.Status, run.Error = RunFailed, err.Error()
+				return run
+			}
+			run.Steps = append(run.Steps, step)
+		case ActionNotify:
+			if e.Notify != nil {
+				e.Notify(rule.Name, action.Message)
+			}
+			run.Steps = append(run.Steps, "notify "+action.Message)
+		case ActionDelay:
+			run.Steps = append(run.Steps, "wait "+time.Duration(action.Delay).String())
+			timer := time.NewTimer(time.Duration(action.Delay))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				run.Status = RunCancelled
+				return run
+			case <-timer.C:
+			}
+			e.mu.Lock()
+			ok, why := checkConditions(rule.Conditions, time.Now(), e.stateLocked)
+			e.mu.Unlock()
+			if !ok {
+				run.Steps = append(run.Steps, "stop, "+why)
+				run.Status = RunStopped
+				return run
+			}
+		}
+	}
+	return run
+}
+
+// stateLocked must be called with e.mu held
+func (e *Engine) stateLocked(device string) string {
+	return e.states[device].State
+}
+
+// States returns the last reported state of every device seen so far
+func (e *Engine) States() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]string, len(e.states))
+	for device, st := range e.states {
+		out[device] = st.State
+	}
+	return out
+}
+
+func (e *Engine) knownDevices() []string {
+	if e.Devices == nil {
+		return nil
+	}
+	var ids []string
+	for _, d := range e.Devices() {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func stateMatches(t Trigger, in Input) bool {
+	return in.Type == events.TypeState && slices.Contains(t.Devices, in.Device) && in.State == t.State
+}
+
+func eventMatches(t Trigger, in Input) bool {
+	if in.Type != t.Event {
+		return false
+	}
+	if len(t.Devices) > 0 && !slices.Contains(t.Devices, in.Device) {
+		return false
+	}
+	return t.State == "" || in.State == t.State
+}
+
+func checkConditions(conditions []Condition, now time.Time, state func(device string) string) (bool, string) {
+	for _, c := range conditions {
+		if c.Device != "" {
+			if got := state(c.Device); got != c.State {
+				if got == "" {
+					got = "unknown"
+				}
+				return false, fmt.Sprintf("%s is %s, not %s", c.Device, got, c.State)
+			}
+		}
+		if !inWindow(now, c.After, c.Before) {
+			return false, fmt.Sprintf("%s is outside %s-%s", now.Format("15:04"), c.After, c.Before)
+		}
+	}
+	return true, ""
+}
+
+// payloadState reads "state" from a zigbee2mqtt payload, upper-cased
+func payloadState(payload json.RawMessage) string {
+	var p struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return ""
+	}
+	return strings.ToUpper(p.State)
+}
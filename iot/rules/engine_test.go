@@ -0,0 +1,144 @@
+package rules
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// executor records set actions and the command events the dispatcher would
+// publish for them
+type executor struct {
+	mu   sync.Mutex
+	sent []events.Event
+}
+
+func (x *executor) Execute(ctx context.Context, cmd command.Command) (command.Result, error) {
+	payload, _ := json.Marshal(map[string]string{"state": cmd.State})
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.sent = append(x.sent, events.Event{Type: events.TypeCommand, Device: cmd.Device, Payload: payload, Time: time.Now()})
+	return command.Result{Device: cmd.Device, State: cmd.State}, nil
+}
+
+func (x *executor) take() []events.Event {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	sent := x.sent
+	x.sent = nil
+	return sent
+}
+
+// testEngine is an Engine as Run sets it up, with rules loaded
+func testEngine(t *testing.T, rules ...Rule) (*Engine, *executor) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	x := &executor{}
+	e := &Engine{
+		Commands: x,
+		ctx:      ctx,
+		states:   make(map[string]deviceState),
+		held:     make(map[holdKey]bool),
+		daily:    make(map[int64]string),
+		running:  make(map[int64]context.CancelFunc),
+	}
+	for i := range rules {
+		rules[i].ID, rules[i].Enabled = int64(i+1), true
+	}
+	e.load(rules)
+	return e, x
+}
+
+func stateEvent(device, state string) events.Event {
+	payload, _ := json.Marshal(map[string]string{"state": state})
+	return events.Event{Type: events.TypeState, Device: device, Payload: payload, Time: time.Now()}
+}
+
+// feed hands event to e and then, like the hub would, every command event
+// the actions it fired publish, until nothing more fires or rounds run out.
+// It returns the commands sent.
+func feed(e *Engine, x *executor, event events.Event, rounds int) []string {
+	var sent []string
+	pending := []events.Event{event}
+	for range rounds {
+		for _, ev := range pending {
+			e.handle(ev, true)
+		}
+		e.wg.Wait()
+		pending = x.take()
+		if len(pending) == 0 {
+			break
+		}
+		for _, ev := range pending {
+			sent = append(sent, ev.Device+" "+payloadState(ev.Payload))
+		}
+	}
+	return sent
+}
+
+func TestEngineRunsActions(t *testing.T) {
+	var notified []string
+	e, x := testEngine(t, Rule{
+		Name:       "porch",
+		Trigger:    Trigger{Type: TriggerState, Devices: []string{"light1"}, State: "ON"},
+		Conditions: []Condition{{Device: "light3", State: "OFF"}},
+		Actions: []Action{
+			{Type: ActionNotify, Message: "porch on"},
+			{Type: ActionSet, Device: "light2", State: "ON"},
+		},
+	})
+	e.Notify = func(title, description string) { notified = append(notified, description) }
+
+	// สถานะแรกที่เห็นแค่จำไว้ ไม่ยิง
+	if sent := feed(e, x, stateEvent("light1", "ON"), 5); len(sent) != 0 {
+		t.Fatalf("first state fired %v", sent)
+	}
+	e.handle(stateEvent("light1", "OFF"), true)
+	e.handle(stateEvent("light3", "ON"), true)
+	if sent := feed(e, x, stateEvent("light1", "ON"), 5); len(sent) != 0 {
+		t.Fatalf("fired %v with light3 ON", sent)
+	}
+
+	e.handle(stateEvent("light1", "OFF"), true)
+	e.handle(stateEvent("light3", "OFF"), true)
+	sent := feed(e, x, stateEvent("light1", "ON"), 5)
+	if !slices.Equal(sent, []string{"light2 ON"}) {
+		t.Errorf("sent %v, want [light2 ON]", sent)
+	}
+	if !slices.Equal(notified, []string{"porch on"}) {
+		t.Errorf("notified %v, want [porch on]", notified)
+	}
+}
+
+func TestEngineSkipsSelfTriggeringRules(t *testing.T) {
+	e, x := testEngine(t,
+		Rule{
+			Name:    "keep light3 on",
+			Trigger: Trigger{Type: TriggerEvent, Event: events.TypeCommand, Devices: []string{"light3"}},
+			Actions: []Action{{Type: ActionSet, Device: "light3", State: "ON"}},
+		},
+		Rule{
+			Name:    "light2 follows light1",
+			Trigger: Trigger{Type: TriggerEvent, Event: events.TypeCommand, Devices: []string{"light1"}},
+			Actions: []Action{{Type: ActionSet, Device: "light2", State: "ON"}},
+		},
+	)
+	command := func(device, state string) events.Event {
+		ev := stateEvent(device, state)
+		ev.Type = events.TypeCommand
+		return ev
+	}
+
+	if sent := feed(e, x, command("light3", "OFF"), 10); len(sent) != 0 {
+		t.Errorf("self-triggering rule sent %v", sent)
+	}
+	if sent := feed(e, x, command("light1", "ON"), 10); !slices.Equal(sent, []string{"light2 ON"}) {
+		t.Errorf("sent %v, want [light2 ON]", sent)
+	}
+}
@@ -0,0 +1,161 @@
+package rules
+
+import (
+	"Panong/pkg/response"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler struct {
+	Engine *Engine
+}
+
+func ruleID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// decodeRule reads a rule body; rules are enabled unless the body says
+// otherwise
+func (h Handler) decodeRule(w http.ResponseWriter, r *http.Request) (Rule, bool) {
+	rule := Rule{Enabled: true}
+	if err := render.DecodeJSON(r.Body, &rule); err != nil {
+		response.BadRequest(w, r, "invalid rule: "+err.Error())
+		return rule, false
+	}
+	if err := rule.Validate(h.Engine.knownDevices()); err != nil {
+		response.Fail(w, r, err)
+		return rule, false
+	}
+	return rule, true
+}
+
+func (h Handler) reload(r *http.Request) {
+	if err := h.Engine.Reload(r.Context()); err != nil {
+		log.Println("[rules] reload failed:", err)
+	}
+}
+
+func (h Handler) List(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.Engine.Store.List(r.Context())
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, rules)
+}
+
+func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(r)
+	if !ok {
+		response.Fail(w, r, ErrRuleNotFound)
+		return
+	}
+	rule, err := h.Engine.Store.Get(r.Context(), id)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, rule)
+}
+
+func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rule, err := h.Engine.Store.Create(r.Context(), rule)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	h.reload(r)
+	response.JSON(w, r, http.StatusCreated, rule)
+}
+
+func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(r)
+	if !ok {
+		response.Fail(w, r, ErrRuleNotFound)
+		return
+	}
+	rule, ok := h.decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+	rule, err := h.Engine.Store.Update(r.Context(), rule)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	h.reload(r)
+	response.OK(w, r, rule)
+}
+
+func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(r)
+	if !ok {
+		response.Fail(w, r, ErrRuleNotFound)
+		return
+	}
+	if err := h.Engine.Store.Delete(r.Context(), id); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	h.reload(r)
+	response.OK(w, r, "deleted")
+}
+
+func (h Handler) Runs(w http.ResponseWriter, r *http.Request) {
+	id, ok := ruleID(r)
+	if !ok {
+		response.Fail(w, r, ErrRuleNotFound)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	runs, err := h.Engine.Store.Runs(r.Context(), id, limit)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, runs)
+}
+
+// DryRun evaluates a stored or posted rule without publishing anything
+func (h Handler) DryRun(w http.ResponseWriter, r *http.Request) {
+	var req DryRunRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		response.BadRequest(w, r, "invalid dry run request: "+err.Error())
+		return
+	}
+
+	var rule Rule
+	switch {
+	case req.Rule != nil:
+		rule = *req.Rule
+	case req.RuleID > 0:
+		stored, err := h.Engine.Store.Get(r.Context(), req.RuleID)
+		if err != nil {
+			response.Fail(w, r, err)
+			return
+		}
+		rule = stored
+	default:
+		response.BadRequest(w, r, "rule or rule_id required")
+		return
+	}
+	if err := rule.Validate(h.Engine.knownDevices()); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.OK(w, r, h.Engine.DryRun(rule, req))
+}
@@ -0,0 +1,254 @@
+package rules
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/pkg/response"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRule  = errors.New("invalid rule")
+	ErrRuleNotFound = errors.New("rule not found")
+	ErrNoDatabase   = errors.New("rules are not available without a database")
+)
+
+func init() {
+	response.RegisterError(ErrInvalidRule, http.StatusBadRequest, response.CodeBadRequest)
+	response.RegisterError(ErrRuleNotFound, http.StatusNotFound, response.CodeNotFound)
+	response.RegisterError(ErrNoDatabase, http.StatusServiceUnavailable, response.CodeServiceUnavailable)
+}
+
+const (
+	TriggerState = "state"
+	TriggerTime  = "time"
+	TriggerEvent = "event"
+
+	ActionSet    = "set"
+	ActionNotify = "notify"
+	ActionDelay  = "delay"
+)
+
+// Rule is stored as one row; Trigger, Conditions and Actions go in the
+// definition column as JSON.
+type Rule struct {
+	ID         int64       `json:"id"`
+	Name       string      `json:"name"`
+	Enabled    bool        `json:"enabled"`
+	Trigger    Trigger     `json:"trigger"`
+	Conditions []Condition `json:"conditions"`
+	Actions    []Action    `json:"actions"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+type Trigger struct {
+	// Type is state, time or event
+	Type string `json:"type"`
+
+	// state: one of Devices changes to State, and if For is set stays
+	// there that long. event: an event of type Event from one of Devices
+	// (any device if empty), whose payload state is State if set.
+	Devices []string `json:"devices,omitempty"`
+	State   string   `json:"state,omitempty"`
+	For     Duration `json:"for,omitempty"`
+	Event   string   `json:"event,omitempty"`
+
+	// time: every day at At ("HH:MM", server time)
+	At string `json:"at,omitempty"`
+}
+
+// Condition must hold when the rule fires and again after every delay.
+// Device/State compares the last reported state; After/Before ("HH:MM")
+// is a time window that may wrap past midnight.
+type Condition struct {
+	Device string `json:"device,omitempty"`
+	State  string `json:"state,omitempty"`
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+type Action struct {
+	// Type is set, notify or delay
+	Type    string   `json:"type"`
+	Device  string   `json:"device,omitempty"`
+	State   string   `json:"state,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Delay   Duration `json:"delay,omitempty"`
+}
+
+// Duration is a Go duration string in JSON ("40m", "10s")
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10m\": %w", err)
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Validate checks the rule on its own; devices are checked against known
+// IDs when known is not nil.
+func (r *Rule) Validate(known []string) error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	device := func(field, id string) {
+		if id == "" {
+			fail("%s: device is required", field)
+		} else if known != nil && !slices.Contains(known, id) {
+			fail("%s: unknown device %q", field, id)
+		}
+	}
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		fail("name is required")
+	}
+
+	t := &r.Trigger
+	t.State = strings.ToUpper(t.State)
+	switch t.Type {
+	case TriggerState:
+		if len(t.Devices) == 0 {
+			fail("trigger: at least one device is required")
+		}
+		for _, id := range t.Devices {
+			device("trigger", id)
+		}
+		if t.State == "" {
+			fail("trigger: state is required")
+		}
+	case TriggerTime:
+		if !validClock(t.At) {
+			fail("trigger: at must be HH:MM, got %q", t.At)
+		}
+	case TriggerEvent:
+		if t.Event == "" {
+			fail("trigger: event is required")
+		}
+		for _, id := range t.Devices {
+			device("trigger", id)
+		}
+	default:
+		fail("trigger: type must be state, time or event, got %q", t.Type)
+	}
+	if t.For < 0 {
+		fail("trigger: for can't be negative")
+	}
+
+	for i := range r.Conditions {
+		c := &r.Conditions[i]
+		c.State = strings.ToUpper(c.State)
+		field := fmt.Sprintf("conditions[%d]", i)
+		if c.Device == "" && c.After == "" && c.Before == "" {
+			fail("%s: needs a device/state or an after/before window", field)
+		}
+		if c.Device != "" || c.State != "" {
+			device(field, c.Device)
+			if c.State == "" {
+				fail("%s: state is required", field)
+			}
+		}
+		for _, clock := range []string{c.After, c.Before} {
+			if clock != "" && !validClock(clock) {
+				fail("%s: %q is not HH:MM", field, clock)
+			}
+		}
+	}
+
+	if len(r.Actions) == 0 {
+		fail("at least one action is required")
+	}
+	for i := range r.Actions {
+		a := &r.Actions[i]
+		a.State = strings.ToUpper(a.State)
+		field := fmt.Sprintf("actions[%d]", i)
+		switch a.Type {
+		case ActionSet:
+			device(field, a.Device)
+			if !slices.Contains(command.States, a.State) {
+				fail("%s: state must be one of %s", field, strings.Join(command.States, ", "))
+			}
+		case ActionNotify:
+			if a.Message == "" {
+				fail("%s: message is required", field)
+			}
+		case ActionDelay:
+			if a.Delay <= 0 {
+				fail("%s: delay must be positive", field)
+			}
+		default:
+			fail("%s: type must be set, notify or delay, got %q", field, a.Type)
+		}
+	}
+
+	if i := r.selfTriggering(); i >= 0 {
+		a := r.Actions[i]
+		fail("actions[%d]: set %s %s makes the command event that triggers this rule, it would fire forever", i, a.Device, a.State)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+	}
+	return nil
+}
+
+// selfTriggering returns the index of the first set action whose command
+// event matches the rule's own trigger, or -1
+func (r *Rule) selfTriggering() int {
+	if r.Trigger.Type != TriggerEvent || r.Trigger.Event != events.TypeCommand {
+		return -1
+	}
+	for i, a := range r.Actions {
+		if a.Type == ActionSet && eventMatches(r.Trigger, Input{Type: events.TypeCommand, Device: a.Device, State: a.State}) {
+			return i
+		}
+	}
+	return -1
+}
+
+func validClock(s string) bool {
+	_, err := time.Parse("15:04", s)
+	return err == nil
+}
+
+// inWindow reports whether now's clock time is in [after, before). An
+// empty bound is open.
+func inWindow(now time.Time, after, before string) bool {
+	clock := now.Format("15:04")
+	switch {
+	case after == "" && before == "":
+		return true
+	case after == "":
+		return clock < before
+	case before == "":
+		return clock >= after
+	case after <= before:
+		return clock >= after && clock < before
+	default:
+		// เช่น 18:00-06:00 ข้ามเที่ยงคืน
+		return clock >= after || clock < before
+	}
+}
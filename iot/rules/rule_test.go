@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var known = []string{"light1", "light2", "light3", "water"}
+
+func TestValidate(t *testing.T) {
+	set := func(device, state string) Action { return Action{Type: ActionSet, Device: device, State: state} }
+	for _, tc := range []struct {
+		name string
+		rule Rule
+		// want is part of the error, empty when the rule is valid
+		want string
+	}{
+		{
+			name: "state trigger",
+			rule: Rule{Name: "porch", Trigger: Trigger{Type: TriggerState, Devices: []string{"light1"}, State: "on"}, Actions: []Action{set("light2", "on")}},
+		},
+		{
+			name: "time trigger with a window",
+			rule: Rule{Name: "night", Trigger: Trigger{Type: TriggerTime, At: "18:30"}, Conditions: []Condition{{After: "18:00", Before: "06:00"}}, Actions: []Action{set("light1", "ON")}},
+		},
+		{
+			name: "event trigger",
+			rule: Rule{Name: "water", Trigger: Trigger{Type: TriggerEvent, Event: "availability", Devices: []string{"water"}}, Actions: []Action{{Type: ActionNotify, Message: "valve offline"}}},
+		},
+		{
+			name: "command trigger switching another device",
+			rule: Rule{Name: "follow", Trigger: Trigger{Type: TriggerEvent, Event: "command", Devices: []string{"light1"}}, Actions: []Action{set("light2", "ON")}},
+		},
+		{
+			name: "command trigger on another state",
+			rule: Rule{Name: "undo", Trigger: Trigger{Type: TriggerEvent, Event: "command", Devices: []string{"light1"}, State: "ON"}, Actions: []Action{{Type: ActionDelay, Delay: Duration(time.Minute)}, set("light1", "OFF")}},
+		},
+		{
+			name: "name is required",
+			rule: Rule{Name: "  ", Trigger: Trigger{Type: TriggerTime, At: "07:00"}, Actions: []Action{set("light1", "ON")}},
+			want: "name is required",
+		},
+		{
+			name: "unknown device",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerState, Devices: []string{"light9"}, State: "ON"}, Actions: []Action{set("light1", "ON")}},
+			want: `trigger: unknown device "light9"`,
+		},
+		{
+			name: "state trigger needs a state",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerState, Devices: []string{"light1"}}, Actions: []Action{set("light1", "ON")}},
+			want: "trigger: state is required",
+		},
+		{
+			name: "bad clock",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerTime, At: "25:00"}, Actions: []Action{set("light1", "ON")}},
+			want: "at must be HH:MM",
+		},
+		{
+			name: "unknown trigger",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: "sunset"}, Actions: []Action{set("light1", "ON")}},
+			want: "trigger: type must be state, time or event",
+		},
+		{
+			name: "empty condition",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerTime, At: "07:00"}, Conditions: []Condition{{}}, Actions: []Action{set("light1", "ON")}},
+			want: "conditions[0]: needs a device/state or an after/before window",
+		},
+		{
+			name: "no actions",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerTime, At: "07:00"}},
+			want: "at least one action is required",
+		},
+		{
+			name: "bad state",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerTime, At: "07:00"}, Actions: []Action{set("light1", "DIM")}},
+			want: "actions[0]: state must be one of",
+		},
+		{
+			name: "delay must be positive",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerTime, At: "07:00"}, Actions: []Action{{Type: ActionDelay}}},
+			want: "actions[0]: delay must be positive",
+		},
+		{
+			name: "command trigger switching its own device",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerEvent, Event: "command", Devices: []string{"light1"}}, Actions: []Action{set("light1", "ON")}},
+			want: "actions[0]: set light1 ON makes the command event that triggers this rule",
+		},
+		{
+			name: "command trigger on any device",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerEvent, Event: "command"}, Actions: []Action{{Type: ActionNotify, Message: "hi"}, set("light2", "OFF")}},
+			want: "actions[1]: set light2 OFF makes the command event",
+		},
+		{
+			name: "command trigger on the same state, lower case",
+			rule: Rule{Name: "x", Trigger: Trigger{Type: TriggerEvent, Event: "command", Devices: []string{"light1"}, State: "toggle"}, Actions: []Action{set("light1", "toggle")}},
+			want: "actions[0]: set light1 TOGGLE makes the command event",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate(known)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidRule) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Validate = %v, want ErrInvalidRule with %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return time.Date(2026, 10, 19, parsed.Hour(), parsed.Minute(), 30, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		now, after, before string
+		want               bool
+	}{
+		{"12:00", "", "", true},
+		{"05:59", "", "06:00", true},
+		{"06:00", "", "06:00", false},
+		{"17:59", "18:00", "", false},
+		{"18:00", "18:00", "", true},
+		{"08:00", "08:00", "17:00", true},
+		{"16:59", "08:00", "17:00", true},
+		{"17:00", "08:00", "17:00", false},
+		{"07:59", "08:00", "17:00", false},
+		// ข้ามเที่ยงคืน
+		{"18:00", "18:00", "06:00", true},
+		{"23:59", "18:00", "06:00", true},
+		{"00:00", "18:00", "06:00", true},
+		{"05:59", "18:00", "06:00", true},
+		{"06:00", "18:00", "06:00", false},
+		{"12:00", "18:00", "06:00", false},
+		{"17:59", "18:00", "06:00", false},
+	} {
+		if got := inWindow(at(tc.now), tc.after, tc.before); got != tc.want {
+			t.Errorf("inWindow(%s, %q, %q) = %v, want %v", tc.now, tc.after, tc.before, got, tc.want)
+		}
+	}
+}
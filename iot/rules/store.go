@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Run is one firing of a rule, written once its actions are done
+type Run struct {
+	ID         int64     `json:"id"`
+	RuleID     int64     `json:"rule_id"`
+	Trigger    string    `json:"trigger"`
+	Status     string    `json:"status"`
+	Steps      []string  `json:"steps"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+const (
+	RunDone      = "done"
+	RunStopped   = "stopped"
+	RunFailed    = "failed"
+	RunCancelled = "cancelled"
+)
+
+// definition is what goes in automation_rules.definition
+type definition struct {
+	Trigger    Trigger     `json:"trigger"`
+	Conditions []Condition `json:"conditions"`
+	Actions    []Action    `json:"actions"`
+}
+
+// Store keeps rules and their run log in Postgres. A nil DB makes every
+// call fail with ErrNoDatabase.
+type Store struct {
+	DB *pgxpool.Pool
+}
+
+const ruleColumns = `id, name, enabled, definition, created_at, updated_at`
+
+func scanRule(row pgx.Row) (Rule, error) {
+	var (
+		rule Rule
+		def  []byte
+	)
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Enabled, &def, &rule.CreatedAt, &rule.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rule, ErrRuleNotFound
+	}
+	if err != nil {
+		return rule, err
+	}
+	var d definition
+	if err := json.Unmarshal(def, &d); err != nil {
+		return rule, err
+	}
+	rule.Trigger, rule.Conditions, rule.Actions = d.Trigger, d.Conditions, d.Actions
+	return rule, nil
+}
+
+func marshalDefinition(rule Rule) ([]byte, error) {
+	return json.Marshal(definition{
+		Trigger:    rule.Trigger,
+		Conditions: rule.Conditions,
+		Actions:    rule.Actions,
+	})
+}
+
+func (s Store) List(ctx context.Context) ([]Rule, error) {
+	if s.DB == nil {
+		return nil, ErrNoDatabase
+	}
+	rows, err := s.DB.Query(ctx, `SELECT `+ruleColumns+` FROM automation_rules ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s Store) Get(ctx context.Context, id int64) (Rule, error) {
+	if s.DB == nil {
+		return Rule{}, ErrNoDatabase
+	}
+	return scanRule(s.DB.QueryRow(ctx, `SELECT `+ruleColumns+` FROM automation_rules WHERE id = $1;`, id))
+}
+
+func (s Store) Create(ctx context.Context, rule Rule) (Rule, error) {
+	if s.DB == nil {
+		return Rule{}, ErrNoDatabase
+	}
+	def, err := marshalDefinition(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+	query := `
+    INSERT INTO automation_rules (name, enabled, definition)
+    VALUES ($1, $2, $3)
+    RETURNING ` + ruleColumns + `;
+    `
+	return scanRule(s.DB.QueryRow(ctx, query, rule.Name, rule.Enabled, def))
+}
+
+func (s Store) Update(ctx context.Context, rule Rule) (Rule, error) {
+	if s.DB == nil {
+		return Rule{}, ErrNoDatabase
+	}
+	def, err := marshalDefinition(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+	query := `
+    UPDATE automation_rules
+    SET name = $2, enabled = $3, definition = $4, updated_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    RETURNING ` + ruleColumns + `;
+    `
+	return scanRule(s.DB.QueryRow(ctx, query, rule.ID, rule.Name, rule.Enabled, def))
+}
+
+func (s Store) Delete(ctx context.Context, id int64) error {
+	if s.DB == nil {
+		return ErrNoDatabase
+	}
+	tag, err := s.DB.Exec(ctx, `DELETE FROM automation_rules WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (s Store) LogRun(ctx context.Context, run Run) error {
+	if s.DB == nil {
+		return ErrNoDatabase
+	}
+	steps, err := json.Marshal(run.Steps)
+	if err != nil {
+		return err
+	}
+	query := `
+    INSERT INTO automation_rule_runs (rule_id, trigger, status, steps, error, started_at, finished_at)
+    VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7);
+    `
+	_, err = s.DB.Exec(ctx, query, run.RuleID, run.Trigger, run.Status, steps, run.Error, run.StartedAt, run.FinishedAt)
+	return err
+}
+
+// Runs returns the newest runs of a rule first
+func (s Store) Runs(ctx context.Context, ruleID int64, limit int) ([]Run, error) {
+	if s.DB == nil {
+		return nil, ErrNoDatabase
+	}
+	query := `
+    SELECT id, rule_id, trigger, status, steps, COALESCE(error, ''), started_at, finished_at
+    FROM automation_rule_runs
+    WHERE rule_id = $1
+    ORDER BY id DESC
+    LIMIT $2;
+    `
+	rows, err := s.DB.Query(ctx, query, ruleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var (
+			run   Run
+			steps []byte
+		)
+		if err := rows.Scan(&run.ID, &run.RuleID, &run.Trigger, &run.Status, &steps, &run.Error, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(steps, &run.Steps); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
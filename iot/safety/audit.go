@@ -0,0 +1,98 @@
+package safety
+
+import (
+	"Panong/pkg/response"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNoDatabase      = errors.New("the override log is not available without a database")
+	ErrAuditNotAllowed = errors.New("only admins can read the override log")
+)
+
+func init() {
+	response.RegisterError(ErrNoDatabase, http.StatusServiceUnavailable, response.CodeServiceUnavailable)
+	response.RegisterError(ErrAuditNotAllowed, http.StatusForbidden, response.CodeForbidden)
+}
+
+// Override is one forced command that skipped a re-strike limit
+type Override struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	User      string    `json:"user"`
+	Device    string    `json:"device"`
+	State     string    `json:"state"`
+	Limit     string    `json:"limit"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditLog keeps overrides in Postgres. Without a DB force still works but
+// overrides only go to the server log, and calls fail with ErrNoDatabase.
+type AuditLog struct {
+	DB *pgxpool.Pool
+}
+
+func (a AuditLog) Record(ctx context.Context, o Override) error {
+	if a.DB == nil {
+		return ErrNoDatabase
+	}
+	query := `
+    INSERT INTO command_overrides (user_id, user_name, device, state, "limit", detail)
+    VALUES ($1, $2, $3, $4, $5, $6);
+    `
+	_, err := a.DB.Exec(ctx, query, o.UserID, o.User, o.Device, o.State, o.Limit, o.Detail)
+	return err
+}
+
+// List returns the newest overrides first
+func (a AuditLog) List(ctx context.Context, limit int) ([]Override, error) {
+	if a.DB == nil {
+		return nil, ErrNoDatabase
+	}
+	query := `
+    SELECT id, user_id, user_name, device, state, "limit", detail, created_at
+    FROM command_overrides
+    ORDER BY id DESC
+    LIMIT $1;
+    `
+	rows, err := a.DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []Override{}
+	for rows.Next() {
+		var o Override
+		if err := rows.Scan(&o.ID, &o.UserID, &o.User, &o.Device, &o.State, &o.Limit, &o.Detail, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// Overrides is GET /safety/overrides, for admins only like force itself
+func (a AuditLog) Overrides(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r.Context()) {
+		response.Fail(w, r, ErrAuditNotAllowed)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	overrides, err := a.List(r.Context(), limit)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.OK(w, r, overrides)
+}
@@ -0,0 +1,374 @@
+package safety
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/pkg/auth"
+	"Panong/pkg/config"
+	"Panong/pkg/response"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLimit           = errors.New("safety limit")
+	ErrForceNotAllowed = errors.New("only admins can force a command")
+)
+
+func init() {
+	response.RegisterError(ErrLimit, http.StatusConflict, response.CodeConflict)
+	response.RegisterError(ErrForceNotAllowed, http.StatusForbidden, response.CodeForbidden)
+}
+
+const (
+	LimitLightsOn = "max_lights_on"
+	LimitPower    = "power_budget_watts"
+	// re-strike protection, the only limits force can skip
+	LimitMinOff = "min_off_time"
+	LimitMinOn  = "min_on_time"
+)
+
+// LimitError says which limit rejected a command. It converts to a 409
+// response.Error with itself as the details.
+type LimitError struct {
+	Limit  string   `json:"limit"`
+	Device string   `json:"device"`
+	Max    float64  `json:"max,omitempty"`
+	Would  float64  `json:"would,omitempty"`
+	On     []string `json:"on,omitempty"`
+
+	MinTime          string `json:"min_time,omitempty"`
+	Remaining        string `json:"remaining,omitempty"`
+	RemainingSeconds int    `json:"remaining_seconds,omitempty"`
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitLightsOn:
+		return fmt.Sprintf("%s: turning on %s would make %g lights on, the limit is %g (%s on)",
+			ErrLimit, e.Device, e.Would, e.Max, strings.Join(e.On, ", "))
+	case LimitMinOff:
+		return fmt.Sprintf("%s: %s must stay off for %s after switching off, %s left",
+			ErrLimit, e.Device, e.MinTime, e.Remaining)
+	case LimitMinOn:
+		return fmt.Sprintf("%s: %s must stay on for %s after switching on, %s left",
+			ErrLimit, e.Device, e.MinTime, e.Remaining)
+	default:
+		return fmt.Sprintf("%s: turning on %s would draw %gW, the power budget is %gW (%s on)",
+			ErrLimit, e.Device, e.Would, e.Max, strings.Join(e.On, ", "))
+	}
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimit
+}
+
+func (e *LimitError) As(target any) bool {
+	out, ok := target.(**response.Error)
+	if !ok {
+		return false
+	}
+	*out = response.NewError(http.StatusConflict, response.CodeConflict, e.Error()).WithDetails(e)
+	return true
+}
+
+type deviceState struct {
+	State string
+	Since time.Time
+	// Changed is false for the first state seen after startup, whose Since
+	// is not a real switch time
+	Changed bool
+}
+
+// Interlock enforces config.SafetyConfig as a command.Guard and closes a
+// valve that has been open longer than MaxValveOpen, whoever opened it.
+// Device states come from the event stream plus the commands it admitted.
+type Interlock struct {
+	Config   *config.Store
+	Hub      *events.Hub
+	Commands *command.Dispatcher
+	Audit    AuditLog
+	// Notify reports valves closed by the watchdog
+	Notify func(title, description string)
+
+	mu     sync.Mutex
+	states map[string]deviceState
+	// closing holds valves the watchdog already tried to close, until the
+	// next attempt is due
+	closing map[string]time.Time
+}
+
+// Admit implements command.Guard
+func (i *Interlock) Admit(ctx context.Context, cmd command.Command) (func(bool), error) {
+	cfg := i.Config.Get()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.states == nil {
+		i.states = make(map[string]deviceState)
+	}
+
+	prev, known := i.states[cmd.Device]
+	next := cmd.State
+	if next == "TOGGLE" {
+		// ไม่รู้สถานะก็ถือว่าจะเปิด กันไว้ก่อน
+		next = "ON"
+		if prev.State == "ON" {
+			next = "OFF"
+		}
+	}
+
+	if next == "ON" && prev.State != "ON" {
+		if err := i.check(cfg, cmd.Device); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	overridden := restrike(cfg, cmd.Device, prev, next, now)
+	if overridden != nil {
+		if !cmd.Force {
+			return nil, overridden
+		}
+		if !mayForce(ctx) {
+			return nil, fmt.Errorf("%w past %s", ErrForceNotAllowed, overridden.Limit)
+		}
+	}
+
+	// นับว่าเปลี่ยนแล้วระหว่าง publish กันคำสั่งพร้อมกันหลุด limit
+	i.set(cmd.Device, next, now)
+	return func(published bool) {
+		if published {
+			if overridden != nil {
+				i.audit(ctx, cmd, overridden)
+			}
+			return
+		}
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if known {
+			i.states[cmd.Device] = prev
+		} else {
+			delete(i.states, cmd.Device)
+		}
+	}, nil
+}
+
+// check must be called with i.mu held
+func (i *Interlock) check(cfg *config.Config, device string) error {
+	lights := cfg.Lights.IDs()
+	watts := cfg.Safety.Watts()
+
+	var on []string
+	lightsOn, power := 0, watts[device]
+	for id, st := range i.states {
+		if st.State != "ON" || id == device {
+			continue
+		}
+		on = append(on, id)
+		if slices.Contains(lights, id) {
+			lightsOn++
+		}
+		power += watts[id]
+	}
+	slices.Sort(on)
+
+	if limit := cfg.Safety.MaxLightsOn; limit > 0 && slices.Contains(lights, device) && lightsOn+1 > limit {
+		return &LimitError{Limit: LimitLightsOn, Device: device, Max: float64(limit), Would: float64(lightsOn + 1), On: on}
+	}
+	if budget := cfg.Safety.PowerBudget; budget > 0 && power > budget {
+		return &LimitError{Limit: LimitPower, Device: device, Max: budget, Would: power, On: on}
+	}
+	return nil
+}
+
+// restrike checks the minimum off and on times. Only switches seen since
+// startup count, a lamp found on or off at startup can be switched at once.
+func restrike(cfg *config.Config, device string, prev deviceState, next string, now time.Time) *LimitError {
+	if !prev.Changed || prev.State == next {
+		return nil
+	}
+	var (
+		limit string
+		min   time.Duration
+	)
+	switch {
+	case prev.State == "OFF" && next == "ON":
+		limit, min = LimitMinOff, cfg.Safety.MinOff()[device]
+	case prev.State == "ON" && next == "OFF":
+		limit, min = LimitMinOn, cfg.Safety.MinOn()[device]
+	default:
+		return nil
+	}
+
+	left := min - now.Sub(prev.Since)
+	if min <= 0 || left <= 0 {
+		return nil
+	}
+	return &LimitError{
+		Limit:            limit,
+		Device:           device,
+		MinTime:          min.String(),
+		Remaining:        left.Round(time.Second).String(),
+		RemainingSeconds: int(math.Ceil(left.Seconds())),
+	}
+}
+
+// isAdmin is true for a logged-in admin. ID 0 is not a database user, so it
+// can't force or be named in the override log whatever Admin says.
+func isAdmin(ctx context.Context) bool {
+	user, ok := auth.FromContext(ctx)
+	return ok && user.Admin && user.ID != 0
+}
+
+// watchdog marks the valve watchdog's own commands, the only ones that may
+// force without an admin behind them
+type watchdog struct{}
+
+var watchdogUser = auth.User{Name: "safety watchdog"}
+
+func mayForce(ctx context.Context) bool {
+	return ctx.Value(watchdog{}) != nil || isAdmin(ctx)
+}
+
+func (i *Interlock) audit(ctx context.Context, cmd command.Command, skipped *LimitError) {
+	user, _ := auth.FromContext(ctx)
+	override := Override{
+		UserID: user.ID,
+		User:   user.Name,
+		Device: cmd.Device,
+		State:  cmd.State,
+		Limit:  skipped.Limit,
+		Detail: skipped.Error(),
+	}
+	log.Printf("[safety] %s (%d) forced %s %s past %s", user.Name, user.ID, cmd.Device, cmd.State, skipped.Limit)
+	// request อาจจบไปแล้ว แต่ audit ต้องลงให้ได้
+	auditCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := i.Audit.Record(auditCtx, override); err != nil && !errors.Is(err, ErrNoDatabase) {
+		log.Println("[safety] failed to record override:", err)
+	}
+}
+
+// set must be called with i.mu held
+func (i *Interlock) set(device, state string, at time.Time) {
+	if prev, ok := i.states[device]; ok && prev.State == state {
+		return
+	}
+	_, known := i.states[device]
+	i.states[device] = deviceState{State: state, Since: at, Changed: known}
+	delete(i.closing, device)
+}
+
+// Run follows device states and runs the valve watchdog until ctx is done.
+func (i *Interlock) Run(ctx context.Context) {
+	i.mu.Lock()
+	if i.states == nil {
+		i.states = make(map[string]deviceState)
+	}
+	i.closing = make(map[string]time.Time)
+	i.mu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastID uint64
+	for {
+		backlog, ch, cancel := i.Hub.Listen(lastID)
+		for _, event := range backlog {
+			i.observe(event)
+			lastID = event.ID
+		}
+
+		open := true
+		for open {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case now := <-ticker.C:
+				i.watchdog(ctx, now)
+			case event, ok := <-ch:
+				if !ok {
+					open = false
+					break
+				}
+				i.observe(event)
+				lastID = event.ID
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (i *Interlock) observe(event events.Event) {
+	if event.Type != events.TypeState {
+		return
+	}
+	var p struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(event.Payload, &p); err != nil || p.State == "" {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.set(event.Device, strings.ToUpper(p.State), event.Time)
+}
+
+func (i *Interlock) watchdog(ctx context.Context, now time.Time) {
+	cfg := i.Config.Get()
+	limit := cfg.Safety.MaxValveOpen
+	if limit <= 0 {
+		return
+	}
+
+	var due []string
+	i.mu.Lock()
+	for _, valve := range cfg.Valves.IDs() {
+		st, ok := i.states[valve]
+		if !ok || st.State != "ON" || now.Sub(st.Since) < limit {
+			continue
+		}
+		if retry, tried := i.closing[valve]; tried && now.Before(retry) {
+			continue
+		}
+		i.closing[valve] = now.Add(30 * time.Second)
+		due = append(due, valve)
+	}
+	i.mu.Unlock()
+
+	for _, valve := range due {
+		go i.close(ctx, valve, limit)
+	}
+}
+
+func (i *Interlock) close(ctx context.Context, valve string, limit time.Duration) {
+	// ปิดในนาม watchdog ข้าม min on ได้ และมี audit
+	ctx = context.WithValue(auth.WithUser(ctx, watchdogUser), watchdog{}, true)
+	_, err := i.Commands.Execute(ctx, command.Command{Type: "valve", Device: valve, State: "OFF", Force: true})
+	if err != nil {
+		log.Printf("[safety] %s open longer than %s, failed to close it: %v", valve, limit, err)
+		return
+	}
+	log.Printf("[safety] %s open longer than %s, closed it", valve, limit)
+	if i.Notify != nil {
+		i.Notify("Valve closed by safety limit", fmt.Sprintf("%s was open longer than %s (MAX_VALVE_OPEN)", valve, limit))
+	}
+}
@@ -0,0 +1,62 @@
+package safety
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/iottest"
+	"Panong/pkg/auth"
+	"Panong/pkg/response"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testInterlock(t *testing.T) *Interlock {
+	t.Helper()
+	store := iottest.Config(t, "DEVICE_MIN_OFF=light1=15m")
+	i := &Interlock{Config: store}
+	// light1 เพิ่งปิดไป ยังไม่ครบ min off
+	i.states = map[string]deviceState{"light1": {State: "OFF", Since: time.Now(), Changed: true}}
+	return i
+}
+
+func TestForceNeedsLoggedInAdmin(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"anonymous", context.Background(), ErrForceNotAllowed},
+		{"shared secret", auth.WithUser(context.Background(), auth.System), ErrForceNotAllowed},
+		{"admin without id", auth.WithUser(context.Background(), auth.User{Name: "system", Admin: true}), ErrForceNotAllowed},
+		{"user", auth.WithUser(context.Background(), auth.User{ID: 2, Name: "somchai"}), ErrForceNotAllowed},
+		{"admin", auth.WithUser(context.Background(), auth.User{ID: 1, Name: "admin", Admin: true}), nil},
+		{"watchdog", context.WithValue(auth.WithUser(context.Background(), watchdogUser), watchdog{}, true), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			i := testInterlock(t)
+			commit, err := i.Admit(tc.ctx, command.Command{Device: "light1", State: "ON", Force: true})
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("Admit = %v, want %v", err, tc.want)
+			}
+			if err != nil && response.From(err).Status != http.StatusForbidden {
+				t.Errorf("?force=true answers %d, want 403", response.From(err).Status)
+			}
+			if commit != nil {
+				commit(false)
+			}
+		})
+	}
+}
+
+func TestWithoutForceRestrikeIsRefused(t *testing.T) {
+	i := testInterlock(t)
+	admin := auth.WithUser(context.Background(), auth.User{ID: 1, Name: "admin", Admin: true})
+
+	_, err := i.Admit(admin, command.Command{Device: "light1", State: "ON"})
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Limit != LimitMinOff {
+		t.Fatalf("Admit = %v, want %s", err, LimitMinOff)
+	}
+}
@@ -0,0 +1,57 @@
+package throttle
+
+import "time"
+
+// buckets are token buckets by key. Each holds up to burst tokens and gains
+// perMinute of them a minute; a key not seen yet starts full.
+type buckets map[string]*bucket
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// refill must be called before reading b.tokens
+func (b *bucket) refill(perMinute, burst float64, now time.Time) {
+	b.tokens = min(burst, b.tokens+now.Sub(b.at).Minutes()*perMinute)
+	b.at = now
+}
+
+// wait says how long until key has a token, 0 when it has one now
+func (bs buckets) wait(key string, perMinute float64, burst int, now time.Time) time.Duration {
+	if perMinute <= 0 {
+		return 0
+	}
+	b, ok := bs[key]
+	if !ok {
+		return 0
+	}
+	b.refill(perMinute, float64(burst), now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / perMinute * float64(time.Minute))
+}
+
+// take spends a token of key; call wait first
+func (bs buckets) take(key string, perMinute float64, burst int, now time.Time) {
+	if perMinute <= 0 {
+		return
+	}
+	b, ok := bs[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), at: now}
+		bs[key] = b
+	}
+	b.refill(perMinute, float64(burst), now)
+	b.tokens--
+}
+
+// prune drops buckets that are full again, they behave like new ones
+func (bs buckets) prune(perMinute float64, burst int, now time.Time) {
+	for key, b := range bs {
+		if perMinute <= 0 || b.tokens+now.Sub(b.at).Minutes()*perMinute >= float64(burst) {
+			delete(bs, key)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package throttle
+
+import (
+	"Panong/pkg/auth"
+	"Panong/pkg/config"
+	"Panong/pkg/metrics"
+	"Panong/pkg/response"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Throttle keeps people mashing the switch in the app from chattering the
+// relays. Commands are limited per user and per device with token buckets,
+// and a user repeating their last command to a device within
+// COMMAND_COALESCE_WINDOW gets the first answer again instead of it being
+// published twice.
+type Throttle struct {
+	Config *config.Store
+
+	mu      sync.Mutex
+	users   buckets
+	devices buckets
+	// recent is the last command of each user to each device (or batch of
+	// devices)
+	recent map[string]*call
+	pruned time.Time
+}
+
+// call is a command in flight or answered within the coalesce window
+type call struct {
+	command string
+	done    chan struct{}
+	value   any
+	err     error
+	// at is when it was answered, zero while in flight
+	at time.Time
+}
+
+// LimitError is returned when the caller or a device is out of tokens
+type LimitError struct {
+	// Who ran out, "you" for the caller or a device ID
+	Who               string `json:"-"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("too many commands for %s, retry in %ds", e.Who, e.RetryAfterSeconds)
+}
+
+func (e *LimitError) As(target any) bool {
+	out, ok := target.(**response.Error)
+	if !ok {
+		return false
+	}
+	*out = response.NewError(http.StatusTooManyRequests, response.CodeTooManyRequests, e.Error()).WithDetails(e)
+	return true
+}
+
+// errPanicked is what callers coalesced into a command get when it panicked
+var errPanicked = errors.New("the command this one was coalesced into failed")
+
+// Commands throttles a PUT /{device}/{action} route; param names the device
+// URL parameter.
+func (t *Throttle) Commands(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			command := strings.ToUpper(chi.URLParam(r, "action")) + "?" + r.URL.RawQuery
+			t.serve(w, r, next, []string{chi.URLParam(r, param)}, command)
+		})
+	}
+}
+
+// Batch throttles a route that commands several devices at once, such as
+// PUT /light/all/{action}. targets lists the devices a request switches; it
+// may read the body, which is put back for the handler. A batch costs the
+// user one token and every device one, and repeating the same batch within
+// the coalesce window gets the first answer.
+func (t *Throttle) Batch(targets func(r *http.Request) ([]string, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				response.BadRequest(w, r, "can't read request body: "+err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			devices, err := targets(r)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if err != nil || len(devices) == 0 {
+				// ให้ handler ตอบ 400 เอง
+				next.ServeHTTP(w, r)
+				return
+			}
+			slices.Sort(devices)
+			devices = slices.Compact(devices)
+			command := strings.ToUpper(chi.URLParam(r, "action")) + "?" + r.URL.RawQuery + "\n" + string(body)
+			t.serve(w, r, next, devices, command)
+		})
+	}
+}
+
+func (t *Throttle) serve(w http.ResponseWriter, r *http.Request, next http.Handler, devices []string, command string) {
+	value, coalesced, err := t.Do(r.Context(), auth.ClientKey(r), devices, command, func() (any, error) {
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		a := &answer{status: rec.status(), header: w.Header().Clone(), body: rec.body.Bytes()}
+		if a.status >= 300 {
+			return a, errFailed
+		}
+		return a, nil
+	})
+	var limited *LimitError
+	switch {
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(limited.RetryAfterSeconds))
+		response.Fail(w, r, err)
+	case coalesced:
+		if a, ok := value.(*answer); ok {
+			a.replay(w)
+		} else if r.Context().Err() == nil {
+			response.Fail(w, r, err)
+		}
+	}
+}
+
+// Do runs fn as client's command to devices unless client or one of the
+// devices is out of tokens, which returns a *LimitError. The same command
+// from the same client to the same devices within the coalesce window isn't
+// run again: it waits for the first one and returns its value and error with
+// coalesced set. A command that failed is only shared with those already
+// waiting for it.
+func (t *Throttle) Do(ctx context.Context, client string, devices []string, command string, fn func() (any, error)) (value any, coalesced bool, err error) {
+	cfg := t.Config.Get().RateLimit
+	key := client + " " + strings.Join(devices, ",")
+	now := time.Now()
+
+	t.mu.Lock()
+	t.prune(cfg, now)
+	if c, ok := t.recent[key]; ok && c.command == command && cfg.CoalesceWindow > 0 {
+		t.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+		metrics.CommandThrottled("coalesced")
+		return c.value, true, c.err
+	}
+
+	wait, who := t.users.wait(client, cfg.UserRate, cfg.UserBurst, now), "you"
+	for _, device := range devices {
+		if d := t.devices.wait(device, cfg.DeviceRate, cfg.DeviceBurst, now); d > wait {
+			wait, who = d, device
+		}
+	}
+	if wait > 0 {
+		t.mu.Unlock()
+		metrics.CommandThrottled("rate_limit")
+		return nil, false, &LimitError{Who: who, RetryAfterSeconds: int(math.Ceil(wait.Seconds()))}
+	}
+	t.users.take(client, cfg.UserRate, cfg.UserBurst, now)
+	for _, device := range devices {
+		t.devices.take(device, cfg.DeviceRate, cfg.DeviceBurst, now)
+	}
+
+	if cfg.CoalesceWindow <= 0 {
+		t.mu.Unlock()
+		value, err = fn()
+		return value, false, err
+	}
+	c := &call{command: command, done: make(chan struct{}), err: errPanicked}
+	t.recent[key] = c
+	t.mu.Unlock()
+
+	// defer เผื่อ fn panic คนที่รอคำสั่งเดียวกันจะได้ไม่ค้าง
+	defer func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		c.at = time.Now()
+		// แค่คำสั่งที่สำเร็จถึงจะตอบซ้ำได้ ที่ fail ให้ลองใหม่
+		if c.err != nil && t.recent[key] == c {
+			delete(t.recent, key)
+		}
+		close(c.done)
+	}()
+	value, err = fn()
+	c.value, c.err = value, err
+	return value, false, err
+}
+
+// prune must be called with t.mu held
+func (t *Throttle) prune(cfg config.RateLimitConfig, now time.Time) {
+	if t.recent == nil {
+		t.users, t.devices = buckets{}, buckets{}
+		t.recent = make(map[string]*call)
+	}
+	for key, c := range t.recent {
+		if !c.at.IsZero() && now.Sub(c.at) >= cfg.CoalesceWindow {
+			delete(t.recent, key)
+		}
+	}
+	if now.Sub(t.pruned) < time.Minute {
+		return
+	}
+	t.pruned = now
+	t.users.prune(cfg.UserRate, cfg.UserBurst, now)
+	t.devices.prune(cfg.DeviceRate, cfg.DeviceBurst, now)
+}
+
+// answer is an HTTP response kept for replay; errFailed marks one that
+// isn't a success
+type answer struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+var errFailed = errors.New("command failed")
+
+func (a *answer) replay(w http.ResponseWriter) {
+	for name, values := range a.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Coalesced", "true")
+	w.WriteHeader(a.status)
+	w.Write(a.body)
+}
+
+// recorder passes the response through and keeps a copy for replay
+type recorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
@@ -0,0 +1,209 @@
+package transport
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+var ErrNotConnected = errors.New("not connected")
+
+// FakeBroker is an in-memory broker for tests. Every FakeClient made from
+// it sees the others' messages, retained messages included, and handlers
+// run on one goroutine per client in publish order, like paho.
+type FakeBroker struct {
+	mu       sync.Mutex
+	clients  []*FakeClient
+	retained map[string]Message
+}
+
+func NewFakeBroker() *FakeBroker {
+	return &FakeBroker{retained: make(map[string]Message)}
+}
+
+// Client returns a new connected client
+func (b *FakeBroker) Client() *FakeClient {
+	c := &FakeClient{
+		broker:    b,
+		connected: true,
+		queue:     make(chan func(), 1024),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case deliver := <-c.queue:
+				deliver()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	b.mu.Lock()
+	b.clients = append(b.clients, c)
+	b.mu.Unlock()
+	return c
+}
+
+// Close disconnects every client and stops their delivery goroutines,
+// e.g. in t.Cleanup.
+func (b *FakeBroker) Close() {
+	b.mu.Lock()
+	clients := slices.Clone(b.clients)
+	b.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// Published returns the messages published on topics matching filter since
+// the broker was created, oldest first.
+func (b *FakeBroker) Published(filter string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []Message
+	for _, c := range b.clients {
+		c.mu.Lock()
+		for _, msg := range c.published {
+			if Match(filter, msg.Topic) {
+				out = append(out, msg)
+			}
+		}
+		c.mu.Unlock()
+	}
+	return out
+}
+
+func (b *FakeBroker) publish(msg Message) {
+	b.mu.Lock()
+	if msg.Retained {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	clients := slices.Clone(b.clients)
+	b.mu.Unlock()
+
+	// ผู้รับเห็น retained=false เหมือน broker จริงที่ส่งต่อข้อความสด
+	msg.Retained = false
+	for _, c := range clients {
+		c.deliver(msg)
+	}
+}
+
+type subscription struct {
+	filter  string
+	handler Handler
+}
+
+type FakeClient struct {
+	broker    *FakeBroker
+	queue     chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	connected bool
+	subs      []subscription
+	published []Message
+}
+
+func (c *FakeClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// SetConnected simulates losing or regaining the broker. Subscriptions are
+// kept, as with paho's resume.
+func (c *FakeClient) SetConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = connected
+}
+
+// Close disconnects c for good and stops its delivery goroutine; messages
+// still queued are dropped.
+func (c *FakeClient) Close() {
+	c.SetConnected(false)
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *FakeClient) Publish(topic string, _ byte, retained bool, payload []byte) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	msg := Message{Topic: topic, Payload: slices.Clone(payload), Retained: retained}
+
+	c.mu.Lock()
+	c.published = append(c.published, msg)
+	c.mu.Unlock()
+
+	c.broker.publish(msg)
+	return nil
+}
+
+func (c *FakeClient) Subscribe(filter string, _ byte, handler Handler) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	c.mu.Lock()
+	c.subs = slices.DeleteFunc(c.subs, func(s subscription) bool { return s.filter == filter })
+	c.subs = append(c.subs, subscription{filter: filter, handler: handler})
+	c.mu.Unlock()
+
+	c.broker.mu.Lock()
+	var retained []Message
+	for topic, msg := range c.broker.retained {
+		if Match(filter, topic) {
+			retained = append(retained, msg)
+		}
+	}
+	c.broker.mu.Unlock()
+	for _, msg := range retained {
+		c.enqueue(func() { handler(msg) })
+	}
+	return nil
+}
+
+func (c *FakeClient) Unsubscribe(filters ...string) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs = slices.DeleteFunc(c.subs, func(s subscription) bool {
+		return slices.Contains(filters, s.filter)
+	})
+	return nil
+}
+
+func (c *FakeClient) deliver(msg Message) {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return
+	}
+	var handlers []Handler
+	for _, s := range c.subs {
+		if Match(s.filter, msg.Topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		c.enqueue(func() { h(msg) })
+	}
+}
+
+// enqueue hands deliver to the client's goroutine unless it is closed
+func (c *FakeClient) enqueue(deliver func()) {
+	select {
+	case c.queue <- deliver:
+	case <-c.done:
+	}
+}
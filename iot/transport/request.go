@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoReply is returned by Request when nothing arrives on the reply topic
+// before the timeout.
+var ErrNoReply = errors.New("no reply")
+
+// A client keeps one handler per filter, so concurrent requests that each
+// subscribed to the same reply topic would replace each other's handler and
+// the first to finish would unsubscribe the rest. Requests to the same topic
+// share one subscription instead.
+type replyKey struct {
+	client Client
+	topic  string
+}
+
+type shared struct {
+	mu    sync.Mutex // held while subscribing and unsubscribing
+	users int
+}
+
+var replies = struct {
+	sync.Mutex
+	topics  map[replyKey]*shared
+	waiting map[replyKey][]chan Message
+}{
+	topics:  map[replyKey]*shared{},
+	waiting: map[replyKey][]chan Message{},
+}
+
+// Request publishes payload on topic and returns the next message on
+// replyTopic. Concurrent requests on the same client and reply topic all get
+// the first reply that arrives after they were made.
+func Request(client Client, replyTopic, topic string, payload []byte, timeout time.Duration) (Message, error) {
+	key := replyKey{client: client, topic: replyTopic}
+	ch := make(chan Message, 1)
+
+	replies.Lock()
+	sub, ok := replies.topics[key]
+	if !ok {
+		sub = &shared{}
+		replies.topics[key] = sub
+	}
+	replies.waiting[key] = append(replies.waiting[key], ch)
+	replies.Unlock()
+	defer stopWaiting(key, ch)
+
+	sub.mu.Lock()
+	if sub.users == 0 {
+		if err := client.Subscribe(key.topic, 0, func(msg Message) { reply(key, msg) }); err != nil {
+			sub.mu.Unlock()
+			return Message{}, fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+	sub.users++
+	sub.mu.Unlock()
+	defer func() {
+		sub.mu.Lock()
+		sub.users--
+		if sub.users == 0 {
+			client.Unsubscribe(key.topic)
+		}
+		sub.mu.Unlock()
+	}()
+
+	if err := client.Publish(topic, 0, false, payload); err != nil {
+		return Message{}, fmt.Errorf("failed to publish request: %w", err)
+	}
+
+	select {
+	case msg := <-ch:
+		return msg, nil
+	case <-time.After(timeout):
+		return Message{}, ErrNoReply
+	}
+}
+
+func reply(key replyKey, msg Message) {
+	replies.Lock()
+	waiting := replies.waiting[key]
+	delete(replies.waiting, key)
+	replies.Unlock()
+	for _, ch := range waiting {
+		ch <- msg
+	}
+}
+
+func stopWaiting(key replyKey, ch chan Message) {
+	replies.Lock()
+	defer replies.Unlock()
+	waiting := replies.waiting[key]
+	for i, c := range waiting {
+		if c == ch {
+			replies.waiting[key] = append(waiting[:i:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(replies.waiting[key]) == 0 {
+		delete(replies.waiting, key)
+	}
+}
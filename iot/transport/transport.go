@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+type Handler func(msg Message)
+
+// Client is the part of MQTT the device handlers use. Calls block until the
+// broker acknowledges them (or the QoS 0 write is done).
+type Client interface {
+	IsConnected() bool
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Subscribe(filter string, qos byte, handler Handler) error
+	Unsubscribe(filters ...string) error
+}
+
+// Paho adapts a paho client; connecting and reconnecting stay with paho.
+type Paho struct {
+	Client mqtt.Client
+}
+
+func NewPaho(client mqtt.Client) Paho {
+	return Paho{Client: client}
+}
+
+func (p Paho) IsConnected() bool {
+	return p.Client.IsConnected()
+}
+
+func (p Paho) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := p.Client.Publish(topic, qos, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (p Paho) Subscribe(filter string, qos byte, handler Handler) error {
+	token := p.Client.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(Message{
+			Topic:    msg.Topic(),
+			Payload:  msg.Payload(),
+			Retained: msg.Retained(),
+		})
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (p Paho) Unsubscribe(filters ...string) error {
+	token := p.Client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
+
+// Match reports whether topic matches filter, with MQTT's + and # wildcards.
+// Wildcards at the first level don't match $SYS style topics.
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) {
+			return false
+		}
+		if part != "+" && part != topicParts[i] {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
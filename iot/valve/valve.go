@@ -1,33 +1,48 @@
 package valve
 
 import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/iot/transport"
+	"Panong/pkg/config"
+	"Panong/pkg/metrics"
+	"Panong/pkg/response"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"slices"
+	"strconv"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/go-chi/chi/v5"
-	"github.com/spf13/viper"
 )
 
 type ValveHandler struct {
-	MqttClient mqtt.Client
+	MqttClient transport.Client
+	Commands   *command.Dispatcher
+	Config     *config.Store
+	// StatusTimeout is how long a device gets to answer a status request,
+	// 30s when zero
+	StatusTimeout time.Duration
+}
+
+func (v ValveHandler) statusTimeout() time.Duration {
+	if v.StatusTimeout > 0 {
+		return v.StatusTimeout
+	}
+	return 30 * time.Second
 }
 
 type Payload struct {
 	State string `json:"state"`
 }
 
-var ErrFriendlyNameNotFound = errors.New("friendly name not found.")
+var ErrFriendlyNameNotFound = fmt.Errorf("friendly name not found: %w", command.ErrDeviceNotFound)
 
 func (v ValveHandler) Valves() []string {
-	return []string{
-		viper.GetString("WATER_VALVE"),
-	}
+	return v.Config.Get().Valves.IDs()
 }
 
 func (v ValveHandler) getFriendlyName(light string) (string, error) {
@@ -40,7 +55,20 @@ func (v ValveHandler) getFriendlyName(light string) (string, error) {
 	}
 }
 
-func (v ValveHandler) getZigbee2MQTTValveStatus(client mqtt.Client, valve string) (string, error) {
+// Devices lists the configured valves with their zigbee2mqtt friendly names
+func (v ValveHandler) Devices() []events.Device {
+	var devices []events.Device
+	for _, valve := range v.Valves() {
+		name, err := v.getFriendlyName(valve)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, events.Device{ID: valve, Type: "valve", Name: name})
+	}
+	return devices
+}
+
+func (v ValveHandler) getZigbee2MQTTValveStatus(client transport.Client, valve string) (string, error) {
 	// 1. retry connection MQTT
 	connected := false
 	for range 3 {
@@ -52,7 +80,7 @@ func (v ValveHandler) getZigbee2MQTTValveStatus(client mqtt.Client, valve string
 		time.Sleep(2 * time.Second)
 	}
 	if !connected {
-		return "", fmt.Errorf("MQTT client not connected after retries")
+		return "", fmt.Errorf("%w after retries", command.ErrMQTTDisconnected)
 	}
 
 	// 2. แปลงชื่อ friendly name
@@ -64,89 +92,91 @@ func (v ValveHandler) getZigbee2MQTTValveStatus(client mqtt.Client, valve string
 	subscribedTopic := fmt.Sprintf("zigbee2mqtt/%s", friendlyName)
 	getTopic := fmt.Sprintf("zigbee2mqtt/%s/get", valve)
 
-	// 3. สร้าง channel สำหรับรับสถานะ
-	statusCh := make(chan string, 1)
-
-	// 4. Subscribe topic ของ light
-	token := client.Subscribe(subscribedTopic, 0, func(client mqtt.Client, msg mqtt.Message) {
-		statusCh <- string(msg.Payload())
-	})
-	token.Wait()
-	if err := token.Error(); err != nil {
-		return "", fmt.Errorf("failed to subscribe: %w", err)
-	}
-
-	// 5. Publish request สถานะ
+	// 3. Publish request สถานะแล้วรอ response (request พร้อมกันใช้ subscription เดียวกัน)
 	payload, _ := json.Marshal(map[string]string{"state": "", "battery": ""})
-	pubToken := client.Publish(getTopic, 0, false, payload)
-	pubToken.Wait()
-	if err := pubToken.Error(); err != nil {
-		client.Unsubscribe(subscribedTopic)
-		return "", fmt.Errorf("failed to publish get request: %w", err)
-	}
-
-	// 6. รอ response จาก channel
-	select {
-	case status := <-statusCh:
-		unsubToken := client.Unsubscribe(subscribedTopic)
-		unsubToken.Wait()
-		return status, nil
-	case <-time.After(30 * time.Second):
-		unsubToken := client.Unsubscribe(subscribedTopic)
-		unsubToken.Wait()
-		return "", errors.New("timeout waiting for valve status")
+	sentAt := time.Now()
+	msg, err := transport.Request(client, subscribedTopic, getTopic, payload, v.statusTimeout())
+	switch {
+	case errors.Is(err, transport.ErrNoReply):
+		metrics.StatusTimeout(valve)
+		return "", fmt.Errorf("%w waiting for valve status", command.ErrDeviceTimeout)
+	case err != nil:
+		return "", fmt.Errorf("%w: %w", command.ErrMQTT, err)
 	}
+	metrics.MQTTSubscribed(subscribedTopic)
+	metrics.MQTTPublished(getTopic)
+	metrics.StatusRoundTrip(valve, time.Since(sentAt))
+	metrics.ObserveDeviceStatus("valve", valve, msg.Payload)
+	return string(msg.Payload), nil
 }
 
-func (v ValveHandler) updateZigbee2MQTTValve(client mqtt.Client, action, valve string) error {
+func (v ValveHandler) updateZigbee2MQTTValve(client transport.Client, action, valve string) error {
 	payload, err := json.Marshal(Payload{State: action})
 	if err != nil {
 		return err
 	}
 
 	if !slices.Contains(v.Valves(), valve) {
-		return errors.New("valve not found")
+		return fmt.Errorf("valve %w", command.ErrDeviceNotFound)
+	}
+	if !client.IsConnected() {
+		return command.ErrMQTTDisconnected
 	}
 
-	token := client.Publish(fmt.Sprintf("zigbee2mqtt/%s/set", valve), 0, false, string(payload))
-	token.Wait()
+	setTopic := fmt.Sprintf("zigbee2mqtt/%s/set", valve)
+	if err := client.Publish(setTopic, 0, false, payload); err != nil {
+		return fmt.Errorf("failed to publish set request: %w: %w", command.ErrMQTT, err)
+	}
+	metrics.MQTTPublished(setTopic)
 	time.Sleep(time.Second)
 
 	return nil
 }
 
+func (v ValveHandler) Kind() string {
+	return "valve"
+}
+
+func (v ValveHandler) Has(valve string) bool {
+	return slices.Contains(v.Valves(), valve)
+}
+
+// Publish sends the state to zigbee2mqtt; it is called by command.Dispatcher
+func (v ValveHandler) Publish(valve, state string) error {
+	return v.updateZigbee2MQTTValve(v.MqttClient, state, valve)
+}
+
 func (v ValveHandler) UpdateValve(w http.ResponseWriter, r *http.Request) {
 	valve := chi.URLParam(r, "valve")
 	action := chi.URLParam(r, "action")
+	// ?force=true ให้ admin ข้าม re-strike protection ได้ (มี audit)
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
 
-	err := v.updateZigbee2MQTTValve(v.MqttClient, action, valve)
+	result, err := v.Commands.Execute(r.Context(), command.Command{Type: "valve", Device: valve, State: action, Force: force})
 	if err != nil {
-		http.Error(w, "Failed to publish message", http.StatusInternalServerError)
+		response.Fail(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Valve updated successfully"))
+	response.OK(w, r, result)
 }
 
 func (v ValveHandler) Valve(w http.ResponseWriter, r *http.Request) {
 	valve := chi.URLParam(r, "valve")
 	if valve == "" {
-		http.Error(w, "valve param required", http.StatusBadRequest)
+		response.BadRequest(w, r, "valve param required")
 		return
 	}
 
 	status, err := v.getZigbee2MQTTValveStatus(v.MqttClient, valve)
 	if err != nil {
-		if errors.Is(err, ErrFriendlyNameNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		response.Fail(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(status))
+	if !json.Valid([]byte(status)) {
+		response.Fail(w, r, response.NewError(http.StatusBadGateway, response.CodeInvalidDeviceData, "invalid valve data"))
+		return
+	}
+	response.OK(w, r, json.RawMessage(status))
 }
@@ -0,0 +1,101 @@
+package valve
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/iottest"
+	"Panong/iot/transport"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// setup returns the valve routes on a fake broker. The valve only answers
+// once respond is called.
+func setup(t *testing.T) (http.Handler, *transport.FakeBroker, func(state string)) {
+	t.Helper()
+	broker := transport.NewFakeBroker()
+	t.Cleanup(broker.Close)
+
+	v := ValveHandler{MqttClient: broker.Client(), Config: iottest.Config(t), StatusTimeout: 200 * time.Millisecond}
+	v.Commands = command.NewDispatcher(nil, v)
+
+	r := chi.NewRouter()
+	r.Get("/{valve}", v.Valve)
+	r.Put("/{valve}/{action}", v.UpdateValve)
+
+	respond := func(state string) {
+		iottest.Zigbee2MQTT(t, broker, v.Devices(), map[string]string{"water": state}, map[string]any{"battery": 87})
+	}
+	return r, broker, respond
+}
+
+func TestValveTimeout(t *testing.T) {
+	h, _, _ := setup(t)
+
+	code, body := iottest.Do(t, h, http.MethodGet, "/water")
+	if code != http.StatusGatewayTimeout || body.Error == nil || body.Error.Code != "DEVICE_TIMEOUT" {
+		t.Errorf("GET /water = %d %+v, want 504 DEVICE_TIMEOUT", code, body.Error)
+	}
+}
+
+// the valve answers with its raw zigbee2mqtt payload, battery included
+func TestValveStatus(t *testing.T) {
+	h, broker, respond := setup(t)
+	respond("OFF")
+
+	code, body := iottest.Do(t, h, http.MethodGet, "/water")
+	var status struct {
+		State   string `json:"state"`
+		Battery int    `json:"battery"`
+	}
+	json.Unmarshal(body.Data, &status)
+	if code != http.StatusOK || status.State != "OFF" || status.Battery != 87 {
+		t.Errorf("GET /water = %d %s, want 200 OFF with battery 87", code, body.Data)
+	}
+
+	gets := broker.Published("zigbee2mqtt/water/get")
+	if len(gets) != 1 || !strings.Contains(string(gets[0].Payload), `"battery"`) {
+		t.Errorf("status requests = %v, want one asking for battery", gets)
+	}
+}
+
+func TestSwitchValve(t *testing.T) {
+	h, broker, respond := setup(t)
+	respond("OFF")
+
+	if code, body := iottest.Do(t, h, http.MethodPut, "/water/on"); code != http.StatusOK {
+		t.Fatalf("PUT /water/on = %d %+v", code, body.Error)
+	}
+	sets := broker.Published("zigbee2mqtt/water/set")
+	if len(sets) != 1 || string(sets[0].Payload) != `{"state":"ON"}` {
+		t.Errorf("set requests = %v, want one {\"state\":\"ON\"}", sets)
+	}
+
+	code, body := iottest.Do(t, h, http.MethodGet, "/water")
+	var p Payload
+	json.Unmarshal(body.Data, &p)
+	if code != http.StatusOK || p.State != "ON" {
+		t.Errorf("GET /water after PUT = %d %q, want 200 ON", code, p.State)
+	}
+}
+
+func TestUnknownValve(t *testing.T) {
+	h, broker, _ := setup(t)
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/nope"},
+		{http.MethodPut, "/nope/ON"},
+	} {
+		code, body := iottest.Do(t, h, req.method, req.path)
+		if code != http.StatusNotFound || body.Error == nil || body.Error.Code != "DEVICE_NOT_FOUND" {
+			t.Errorf("%s %s = %d %+v, want 404 DEVICE_NOT_FOUND", req.method, req.path, code, body.Error)
+		}
+	}
+	if sets := broker.Published("zigbee2mqtt/+/set"); len(sets) != 0 {
+		t.Errorf("published %v for an unknown valve", sets)
+	}
+}
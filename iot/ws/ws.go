@@ -0,0 +1,204 @@
+package ws
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/iot/throttle"
+	"Panong/pkg/auth"
+	"Panong/pkg/response"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// คำสั่งรอ publish + sleep ใน handler อยู่แล้ว เผื่อไว้พอ
+	commandTimeout = 30 * time.Second
+	// commands one socket may have running at once, more are refused
+	maxInFlight = 4
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// the socket sits behind AuthMiddleware so any origin holding a token is fine
+	CheckOrigin: func(r *http.Request) bool { return true },
+	// browsers offer ["panong", "token.<token>"], the token must not be echoed
+	Subprotocols: []string{"panong"},
+}
+
+// Request is a message from the client. Type defaults to "command", so the
+// bare form {"device":"light2","state":"ON"} works too.
+type Request struct {
+	ID     string        `json:"id,omitempty"`
+	Type   string        `json:"type,omitempty"`
+	Device string        `json:"device,omitempty"`
+	State  string        `json:"state,omitempty"`
+	Filter events.Filter `json:"filter"`
+}
+
+// Message is sent to the client: an "ack" correlated by ID, or an "event".
+type Message struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	OK     bool            `json:"ok,omitempty"`
+	Result *command.Result `json:"result,omitempty"`
+	Error  *response.Error `json:"error,omitempty"`
+	Event  *events.Event   `json:"event,omitempty"`
+}
+
+// Handler serves the socket. Commands go through Throttle like the HTTP
+// routes, keyed on the same client.
+type Handler struct {
+	Hub      *events.Hub
+	Commands *command.Dispatcher
+	Throttle *throttle.Throttle
+}
+
+type conn struct {
+	ws       *websocket.Conn
+	writeMu  sync.Mutex
+	client   string
+	inFlight chan struct{}
+
+	filterMu   sync.Mutex
+	filter     events.Filter
+	subscribed bool
+}
+
+func (c *conn) send(m Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteJSON(m)
+}
+
+func (c *conn) ping() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+// Serve upgrades GET /ws. Clients send
+//
+//	{"id":"1","type":"subscribe","filter":{"types":["light"],"devices":[]}}
+//	{"id":"2","type":"unsubscribe"}
+//	{"id":"3","device":"light2","state":"ON"}
+//
+// and get {"type":"ack","id":...} back for each, plus {"type":"event"} while
+// subscribed.
+func (h Handler) Serve(w http.ResponseWriter, r *http.Request) {
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("[ws] upgrade failed:", err)
+		return
+	}
+	defer wsConn.Close()
+
+	c := &conn{ws: wsConn, client: auth.ClientKey(r), inFlight: make(chan struct{}, maxInFlight)}
+	_, live, cancel := h.Hub.Listen(^uint64(0))
+	defer cancel()
+
+	ctx, stop := context.WithCancel(r.Context())
+	defer stop()
+	go h.writeLoop(ctx, c, live, stop)
+
+	wsConn.SetReadDeadline(time.Now().Add(pongWait))
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var req Request
+		if err := wsConn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("[ws] read:", err)
+			}
+			return
+		}
+
+		switch req.Type {
+		case "subscribe":
+			c.filterMu.Lock()
+			c.filter = req.Filter
+			c.subscribed = true
+			c.filterMu.Unlock()
+			c.send(Message{Type: "ack", ID: req.ID, OK: true})
+		case "unsubscribe":
+			c.filterMu.Lock()
+			c.subscribed = false
+			c.filterMu.Unlock()
+			c.send(Message{Type: "ack", ID: req.ID, OK: true})
+		case "", "command":
+			// ทำทีละคำสั่งแยก goroutine จะได้ไม่บล็อกการอ่าน แต่จำกัดจำนวนต่อ socket
+			select {
+			case c.inFlight <- struct{}{}:
+				go func() {
+					defer func() { <-c.inFlight }()
+					h.command(ctx, c, req)
+				}()
+			default:
+				c.send(Message{Type: "ack", ID: req.ID, Error: response.NewError(http.StatusTooManyRequests, response.CodeTooManyRequests,
+					fmt.Sprintf("%d commands already running on this socket", maxInFlight))})
+			}
+		default:
+			c.send(Message{Type: "ack", ID: req.ID, Error: response.NewError(http.StatusBadRequest, response.CodeBadRequest, "unknown message type "+req.Type)})
+		}
+	}
+}
+
+func (h Handler) command(ctx context.Context, c *conn, req Request) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	value, _, err := h.Throttle.Do(ctx, c.client, []string{req.Device}, "ws:"+strings.ToUpper(req.State), func() (any, error) {
+		return h.Commands.Execute(ctx, command.Command{Device: req.Device, State: req.State})
+	})
+	if err != nil {
+		c.send(Message{Type: "ack", ID: req.ID, Error: response.From(err)})
+		return
+	}
+	result := value.(command.Result)
+	c.send(Message{Type: "ack", ID: req.ID, OK: true, Result: &result})
+}
+
+func (h Handler) writeLoop(ctx context.Context, c *conn, live <-chan events.Event, stop func()) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	// close the socket so the blocked ReadJSON in Serve returns
+	defer c.ws.Close()
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				return
+			}
+		case event, ok := <-live:
+			if !ok {
+				return
+			}
+			c.filterMu.Lock()
+			deliver := c.subscribed && c.filter.Match(event)
+			c.filterMu.Unlock()
+			if !deliver {
+				continue
+			}
+			if err := c.send(Message{Type: "event", Event: &event}); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package z2msim
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario describes the simulated zigbee2mqtt network. Durations are Go
+// duration strings ("250ms", "1m").
+type Scenario struct {
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"client_id"`
+
+	// BaseTopic is zigbee2mqtt's base_topic, "zigbee2mqtt" by default
+	BaseTopic string `yaml:"base_topic"`
+	// Seed makes drops and jitter repeatable; 0 picks one from the clock
+	Seed int64 `yaml:"seed"`
+
+	Latency Duration `yaml:"latency"`
+	Jitter  Duration `yaml:"jitter"`
+	// DropRate is the chance (0-1) that a reply to /get or /set is lost
+	DropRate float64 `yaml:"drop_rate"`
+	// ReportInterval publishes every device's state periodically, like
+	// devices with reporting configured; 0 disables it
+	ReportInterval Duration `yaml:"report_interval"`
+
+	Devices []DeviceSpec `yaml:"devices"`
+	// Timeline changes devices at fixed offsets from the start
+	Timeline []Step `yaml:"timeline"`
+}
+
+type DeviceSpec struct {
+	// ID is what the server publishes /get and /set to (FIRST_LIGHT, ...)
+	ID           string `yaml:"id"`
+	FriendlyName string `yaml:"friendly_name"`
+	Model        string `yaml:"model"`
+	State        string `yaml:"state"`
+	LinkQuality  int    `yaml:"linkquality"`
+	// Battery is a percentage; leave it out for mains powered relays
+	Battery *float64 `yaml:"battery"`
+	// BatteryDrain is subtracted every ReportInterval. The device goes
+	// offline when the battery is empty.
+	BatteryDrain float64 `yaml:"battery_drain"`
+	Offline      bool    `yaml:"offline"`
+
+	// Per device overrides of the scenario defaults
+	Latency  *Duration `yaml:"latency"`
+	DropRate *float64  `yaml:"drop_rate"`
+}
+
+// Step applies to Device at At: any of State, Offline or Battery that is set.
+type Step struct {
+	At      Duration `yaml:"at"`
+	Device  string   `yaml:"device"`
+	State   string   `yaml:"state"`
+	Offline *bool    `yaml:"offline"`
+	Battery *float64 `yaml:"battery"`
+}
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func LoadScenario(path string) (Scenario, error) {
+	var sc Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("%s: %w", path, err)
+	}
+	if sc.BaseTopic == "" {
+		sc.BaseTopic = "zigbee2mqtt"
+	}
+	if sc.ClientID == "" {
+		sc.ClientID = "z2msim"
+	}
+	return sc, sc.Validate()
+}
+
+func (sc Scenario) Validate() error {
+	var errs []error
+	if sc.DropRate < 0 || sc.DropRate > 1 {
+		errs = append(errs, fmt.Errorf("drop_rate must be between 0 and 1, got %g", sc.DropRate))
+	}
+	ids := map[string]bool{}
+	for i, d := range sc.Devices {
+		if d.ID == "" || d.FriendlyName == "" {
+			errs = append(errs, fmt.Errorf("devices[%d]: id and friendly_name are required", i))
+		}
+		if ids[d.ID] || ids[d.FriendlyName] {
+			errs = append(errs, fmt.Errorf("devices[%d]: %s is defined twice", i, d.ID))
+		}
+		ids[d.ID], ids[d.FriendlyName] = true, true
+		if d.DropRate != nil && (*d.DropRate < 0 || *d.DropRate > 1) {
+			errs = append(errs, fmt.Errorf("devices[%d]: drop_rate must be between 0 and 1", i))
+		}
+	}
+	for i, step := range sc.Timeline {
+		if !ids[step.Device] {
+			errs = append(errs, fmt.Errorf("timeline[%d]: unknown device %q", i, step.Device))
+		}
+		if step.State != "" && !validState(step.State) {
+			errs = append(errs, fmt.Errorf("timeline[%d]: state must be ON or OFF", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validState(state string) bool {
+	state = strings.ToUpper(state)
+	return state == "ON" || state == "OFF"
+}
@@ -0,0 +1,410 @@
+package z2msim
+
+import (
+	"Panong/iot/transport"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Simulator emulates zigbee2mqtt on a broker. It answers /get and /set for
+// the scenario devices and publishes availability and bridge/devices. The
+// timeline drives it, or tests can drive it directly through SetState,
+// SetOffline and SetBattery.
+type Simulator struct {
+	client transport.Client
+	sc     Scenario
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	devices []*device
+}
+
+type device struct {
+	spec        DeviceSpec
+	state       string
+	linkquality int
+	battery     *float64
+	offline     bool
+}
+
+// Status is a snapshot of one simulated device
+type Status struct {
+	ID           string   `json:"id"`
+	FriendlyName string   `json:"friendly_name"`
+	State        string   `json:"state"`
+	LinkQuality  int      `json:"linkquality"`
+	Battery      *float64 `json:"battery,omitempty"`
+	Offline      bool     `json:"offline"`
+}
+
+func New(client transport.Client, sc Scenario) *Simulator {
+	if sc.BaseTopic == "" {
+		sc.BaseTopic = "zigbee2mqtt"
+	}
+	seed := sc.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	s := &Simulator{
+		client: client,
+		sc:     sc,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+	for _, spec := range sc.Devices {
+		d := &device{
+			spec:        spec,
+			state:       strings.ToUpper(spec.State),
+			linkquality: spec.LinkQuality,
+			offline:     spec.Offline,
+		}
+		if d.state == "" {
+			d.state = "OFF"
+		}
+		if spec.Battery != nil {
+			battery := *spec.Battery
+			d.battery = &battery
+		}
+		s.devices = append(s.devices, d)
+	}
+	return s
+}
+
+// Run announces the network, then answers requests and plays the timeline
+// until ctx is done. The client must already be connected.
+func (s *Simulator) Run(ctx context.Context) error {
+	for _, suffix := range []string{"get", "set"} {
+		topic := fmt.Sprintf("%s/+/%s", s.sc.BaseTopic, suffix)
+		if err := s.client.Subscribe(topic, 0, s.handle); err != nil {
+			return fmt.Errorf("subscribe %s: %w", topic, err)
+		}
+	}
+
+	s.publish("bridge/state", true, map[string]string{"state": "online"})
+	s.publishBridgeDevices()
+	for _, status := range s.Devices() {
+		s.publishAvailability(status)
+		if !status.Offline {
+			s.publishState(status)
+		}
+	}
+	log.Printf("[z2msim] simulating %d devices under %s/", len(s.devices), s.sc.BaseTopic)
+
+	timeline := slices.Clone(s.sc.Timeline)
+	slices.SortStableFunc(timeline, func(a, b Step) int {
+		return cmp.Compare(a.At, b.At)
+	})
+	start := time.Now()
+	next := time.NewTimer(time.Hour)
+	defer next.Stop()
+	schedule := func() {
+		next.Stop()
+		if len(timeline) > 0 {
+			next.Reset(time.Until(start.Add(time.Duration(timeline[0].At))))
+		}
+	}
+	schedule()
+
+	var report <-chan time.Time
+	if s.sc.ReportInterval > 0 {
+		ticker := time.NewTicker(time.Duration(s.sc.ReportInterval))
+		defer ticker.Stop()
+		report = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.publish("bridge/state", true, map[string]string{"state": "offline"})
+			return nil
+		case <-next.C:
+			if len(timeline) == 0 {
+				continue
+			}
+			s.apply(timeline[0])
+			timeline = timeline[1:]
+			schedule()
+		case <-report:
+			s.report()
+		}
+	}
+}
+
+func (s *Simulator) Devices() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.devices))
+	for _, d := range s.devices {
+		out = append(out, d.status())
+	}
+	return out
+}
+
+func (s *Simulator) Device(idOrName string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.lookup(idOrName)
+	if d == nil {
+		return Status{}, false
+	}
+	return d.status(), true
+}
+
+// SetState changes a device as if someone flipped it by hand and publishes
+// the new state.
+func (s *Simulator) SetState(idOrName, state string) error {
+	return s.change(idOrName, func(d *device) {
+		d.state = strings.ToUpper(state)
+	})
+}
+
+// SetOffline makes a device stop (or resume) answering and publishes its
+// availability.
+func (s *Simulator) SetOffline(idOrName string, offline bool) error {
+	return s.change(idOrName, func(d *device) {
+		d.offline = offline
+	})
+}
+
+func (s *Simulator) SetBattery(idOrName string, percent float64) error {
+	return s.change(idOrName, func(d *device) {
+		d.battery = &percent
+	})
+}
+
+func (s *Simulator) change(idOrName string, fn func(d *device)) error {
+	s.mu.Lock()
+	d := s.lookup(idOrName)
+	if d == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("z2msim: unknown device %q", idOrName)
+	}
+	wasOffline := d.offline
+	fn(d)
+	status := d.status()
+	s.mu.Unlock()
+
+	if status.Offline != wasOffline {
+		s.publishAvailability(status)
+	}
+	if !status.Offline {
+		s.publishState(status)
+	}
+	return nil
+}
+
+func (s *Simulator) apply(step Step) {
+	err := s.change(step.Device, func(d *device) {
+		if step.State != "" {
+			d.state = strings.ToUpper(step.State)
+		}
+		if step.Offline != nil {
+			d.offline = *step.Offline
+		}
+		if step.Battery != nil {
+			battery := *step.Battery
+			d.battery = &battery
+		}
+	})
+	if err != nil {
+		log.Println("[z2msim]", err)
+		return
+	}
+	log.Printf("[z2msim] timeline %s: %s", time.Duration(step.At), step.Device)
+}
+
+// report drains batteries and publishes every online device's state
+func (s *Simulator) report() {
+	var online, died []Status
+	s.mu.Lock()
+	for _, d := range s.devices {
+		if d.offline {
+			continue
+		}
+		if d.battery != nil && d.spec.BatteryDrain > 0 {
+			*d.battery = max(0, *d.battery-d.spec.BatteryDrain)
+			if *d.battery == 0 {
+				d.offline = true
+				died = append(died, d.status())
+				continue
+			}
+		}
+		online = append(online, d.status())
+	}
+	s.mu.Unlock()
+
+	for _, status := range died {
+		log.Printf("[z2msim] %s battery empty, going offline", status.FriendlyName)
+		s.publishAvailability(status)
+	}
+	for _, status := range online {
+		s.publishState(status)
+	}
+}
+
+// handle answers <base>/<id or friendly name>/get and /set. Offline devices
+// don't answer at all, like a real one out of range.
+func (s *Simulator) handle(msg transport.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic, s.sc.BaseTopic+"/"), "/")
+	if len(parts) != 2 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.lookup(parts[0])
+	if d == nil {
+		log.Printf("[z2msim] %s: no such device", msg.Topic)
+		return
+	}
+	if d.offline {
+		return
+	}
+
+	if parts[1] == "set" {
+		var req struct {
+			State string `json:"state"`
+		}
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			log.Printf("[z2msim] %s: invalid payload %q", msg.Topic, msg.Payload)
+			return
+		}
+		switch state := strings.ToUpper(req.State); state {
+		case "ON", "OFF":
+			d.state = state
+		case "TOGGLE":
+			if d.state == "ON" {
+				d.state = "OFF"
+			} else {
+				d.state = "ON"
+			}
+		default:
+			log.Printf("[z2msim] %s: unsupported state %q", msg.Topic, req.State)
+			return
+		}
+	}
+
+	dropRate := s.sc.DropRate
+	if d.spec.DropRate != nil {
+		dropRate = *d.spec.DropRate
+	}
+	if s.rand.Float64() < dropRate {
+		log.Printf("[z2msim] dropping reply to %s", msg.Topic)
+		return
+	}
+
+	delay := time.Duration(s.sc.Latency)
+	if d.spec.Latency != nil {
+		delay = time.Duration(*d.spec.Latency)
+	}
+	if s.sc.Jitter > 0 {
+		delay += time.Duration(s.rand.Int63n(int64(s.sc.Jitter)))
+	}
+	status := d.status()
+	time.AfterFunc(delay, func() {
+		s.publishState(status)
+	})
+}
+
+// lookup must be called with s.mu held
+func (s *Simulator) lookup(idOrName string) *device {
+	for _, d := range s.devices {
+		if d.spec.ID == idOrName || d.spec.FriendlyName == idOrName {
+			return d
+		}
+	}
+	return nil
+}
+
+func (d *device) status() Status {
+	status := Status{
+		ID:           d.spec.ID,
+		FriendlyName: d.spec.FriendlyName,
+		State:        d.state,
+		LinkQuality:  d.linkquality,
+		Offline:      d.offline,
+	}
+	if d.battery != nil {
+		battery := *d.battery
+		status.Battery = &battery
+	}
+	return status
+}
+
+func (s *Simulator) publishState(status Status) {
+	payload := map[string]any{
+		"state":       status.State,
+		"linkquality": status.LinkQuality,
+	}
+	if status.Battery != nil {
+		payload["battery"] = *status.Battery
+	}
+	s.publish(status.FriendlyName, false, payload)
+}
+
+func (s *Simulator) publishAvailability(status Status) {
+	state := "online"
+	if status.Offline {
+		state = "offline"
+	}
+	s.publish(status.FriendlyName+"/availability", true, map[string]string{"state": state})
+}
+
+func (s *Simulator) publishBridgeDevices() {
+	type definition struct {
+		Model       string `json:"model"`
+		Vendor      string `json:"vendor"`
+		Description string `json:"description"`
+	}
+	type bridgeDevice struct {
+		IEEEAddress  string     `json:"ieee_address"`
+		FriendlyName string     `json:"friendly_name"`
+		Type         string     `json:"type"`
+		Supported    bool       `json:"supported"`
+		Definition   definition `json:"definition"`
+	}
+
+	s.mu.Lock()
+	list := []bridgeDevice{{IEEEAddress: "0x0000000000000000", FriendlyName: "Coordinator", Type: "Coordinator"}}
+	for _, d := range s.devices {
+		kind := "Router"
+		if d.battery != nil {
+			kind = "EndDevice"
+		}
+		model := d.spec.Model
+		if model == "" {
+			model = "SIM-RELAY"
+		}
+		list = append(list, bridgeDevice{
+			IEEEAddress:  d.spec.ID,
+			FriendlyName: d.spec.FriendlyName,
+			Type:         kind,
+			Supported:    true,
+			Definition:   definition{Model: model, Vendor: "z2msim", Description: "Simulated device"},
+		})
+	}
+	s.mu.Unlock()
+
+	s.publish("bridge/devices", true, list)
+}
+
+// publish blocks, so handle only ever calls it from a timer goroutine
+func (s *Simulator) publish(topic string, retained bool, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[z2msim]", err)
+		return
+	}
+	if err := s.client.Publish(s.sc.BaseTopic+"/"+topic, 0, retained, data); err != nil {
+		log.Printf("[z2msim] publish %s: %v", topic, err)
+	}
+}
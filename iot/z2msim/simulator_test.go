@@ -0,0 +1,158 @@
+package z2msim_test
+
+import (
+	"Panong/iot/command"
+	"Panong/iot/iottest"
+	"Panong/iot/light"
+	"Panong/iot/mqttbroker"
+	"Panong/iot/transport"
+	"Panong/iot/z2msim"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-chi/chi/v5"
+)
+
+// broker starts the embedded broker on a free port
+func broker(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ledger, err := mqttbroker.LoadLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttbroker.AllowAll(ledger, "panong", "panong-secret")
+	mqttbroker.AllowAll(ledger, "z2msim", "z2msim-secret")
+	b, err := mqttbroker.New(mqttbroker.Options{Addr: addr, Ledger: ledger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return addr
+}
+
+func connect(t *testing.T, addr, username, password string) transport.Client {
+	t.Helper()
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID(username).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(false)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect as %s: %v", username, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return transport.NewPaho(client)
+}
+
+// TestLightsAgainstSimulator runs the light routes against z2msim over the
+// embedded broker: light1 answers, light2 drops every reply and light3 is
+// taken offline.
+func TestLightsAgainstSimulator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a broker")
+	}
+	addr := broker(t)
+
+	l := light.LightHandler{
+		MqttClient:    connect(t, addr, "panong", "panong-secret"),
+		Config:        iottest.Config(t),
+		StatusTimeout: 500 * time.Millisecond,
+	}
+	l.Commands = command.NewDispatcher(nil, l)
+	r := chi.NewRouter()
+	r.Get("/light/{light}", l.Light)
+	r.Put("/light/{light}/{action}", l.UpdateLight)
+
+	names := map[string]string{}
+	for _, d := range l.Devices() {
+		names[d.ID] = d.Name
+	}
+	never := 1.0
+	sim := z2msim.New(connect(t, addr, "z2msim", "z2msim-secret"), z2msim.Scenario{
+		Seed:    1,
+		Latency: z2msim.Duration(20 * time.Millisecond),
+		Devices: []z2msim.DeviceSpec{
+			{ID: "light1", FriendlyName: names["light1"], LinkQuality: 120},
+			{ID: "light2", FriendlyName: names["light2"], LinkQuality: 80, DropRate: &never},
+			{ID: "light3", FriendlyName: names["light3"], LinkQuality: 45, State: "ON"},
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sim.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	// รอให้ simulator subscribe เสร็จก่อน
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if code, _ := iottest.Do(t, r, http.MethodGet, "/light/light1"); code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("simulator never answered light1")
+		}
+	}
+
+	state := func(path string) (int, light.Payload) {
+		code, body := iottest.Do(t, r, http.MethodGet, path)
+		var p light.Payload
+		json.Unmarshal(body.Data, &p)
+		return code, p
+	}
+
+	if code, p := state("/light/light1"); code != http.StatusOK || p.State != "OFF" {
+		t.Errorf("GET light1 = %d %q, want 200 OFF", code, p.State)
+	}
+	if code, body := iottest.Do(t, r, http.MethodPut, "/light/light1/on"); code != http.StatusOK {
+		t.Fatalf("PUT light1 on = %d %+v", code, body.Error)
+	}
+	if code, p := state("/light/light1"); code != http.StatusOK || p.State != "ON" {
+		t.Errorf("GET light1 after PUT = %d %q, want 200 ON", code, p.State)
+	}
+	if st, _ := sim.Device("light1"); st.State != "ON" {
+		t.Errorf("simulated light1 is %s, want ON", st.State)
+	}
+
+	timeout := func(device string) {
+		t.Helper()
+		code, body := iottest.Do(t, r, http.MethodGet, "/light/"+device)
+		if code != http.StatusGatewayTimeout || body.Error == nil || body.Error.Code != "DEVICE_TIMEOUT" {
+			t.Errorf("GET %s = %d %+v, want 504 DEVICE_TIMEOUT", device, code, body.Error)
+		}
+	}
+	timeout("light2")
+
+	if code, p := state("/light/light3"); code != http.StatusOK || p.State != "ON" {
+		t.Errorf("GET light3 = %d %q, want 200 ON", code, p.State)
+	}
+	if err := sim.SetOffline("light3", true); err != nil {
+		t.Fatal(err)
+	}
+	timeout("light3")
+	if err := sim.SetOffline("light3", false); err != nil {
+		t.Fatal(err)
+	}
+	if code, p := state("/light/light3"); code != http.StatusOK || p.State != "ON" {
+		t.Errorf("GET light3 back online = %d %q, want 200 ON", code, p.State)
+	}
+}
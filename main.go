@@ -1,10 +1,30 @@
 package main
 
 import (
+	"Panong/iot/command"
+	"Panong/iot/events"
+	"Panong/iot/lamp"
 	"Panong/iot/light"
+	"Panong/iot/mqttbroker"
+	"Panong/iot/mqttconn"
+	"Panong/iot/rules"
+	"Panong/iot/safety"
+	"Panong/iot/throttle"
+	"Panong/iot/transport"
 	"Panong/iot/valve"
+	"Panong/iot/ws"
+	"Panong/pkg/auth"
+	"Panong/pkg/config"
+	"Panong/pkg/discordbot"
 	"Panong/pkg/hwinfo"
+	"Panong/pkg/idempotency"
+	"Panong/pkg/lifecycle"
+	"Panong/pkg/metrics"
+	"Panong/pkg/openapi"
 	"Panong/pkg/response"
+	"Panong/web"
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,7 +34,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
-	"github.com/spf13/viper"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
@@ -26,92 +46,413 @@ var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 }
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	fmt.Printf("Connect lost: %v", err)
+	fmt.Printf("Connect lost: %v\n", err)
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-Auth-Token")
+type Health struct {
+	Status string          `json:"status"`
+	MQTT   mqttconn.Status `json:"mqtt"`
+}
 
-		if token == "" || token != viper.GetString("HEADER_SECRET_AUTH") {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+func AuthMiddleware(secret string, sessions *auth.Sessions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := auth.TokenFromRequest(r)
 
-		next.ServeHTTP(w, r)
-	})
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+				next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), auth.System)))
+				return
+			}
+			if session, ok := sessions.Get(token); token != "" && ok {
+				next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), session.User)))
+				return
+			}
+
+			response.Unauthorized(w, r)
+		})
+	}
 }
 
 func main() {
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
+	store, err := config.Load()
 	if err != nil {
-		log.Fatalln("can't read from env")
+		log.Fatalln(err)
 	}
+	cfg := store.Get()
 
 	hwClient, _ := hwinfo.NewSystemInfo()
 
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(AuthMiddleware)
-	r.Use(middleware.Timeout(1 * time.Minute))
+	var db *pgxpool.Pool
+	if cfg.Auth.PSQLConnection != "" {
+		db, err = pgxpool.New(context.Background(), cfg.Auth.PSQLConnection)
+		if err != nil {
+			log.Println("[db] can't connect, login disabled:", err)
+			db = nil
+		}
+	}
 
-	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
-		render.JSON(w, r, response.HTTPResponse{
-			Data:  hwClient.Host,
-			Error: nil,
-		})
+	authHandler := auth.AuthHandler{
+		Authenticator: auth.Authenticator{
+			DB:       db,
+			Pepper:   cfg.Auth.PasswordPepper,
+			Sessions: auth.NewSessions(cfg.Auth.SessionTTL),
+		},
+	}
+
+	var resolver hwinfo.IPResolver
+	if cfg.HWInfo.PublicIPResolverURL != "" {
+		resolver = hwinfo.HTTPResolver{URL: cfg.HWInfo.PublicIPResolverURL}
+	}
+	sampler := hwinfo.NewSampler(hwinfo.SamplerOptions{
+		Interval:         cfg.HWInfo.SampleInterval,
+		History:          cfg.HWInfo.SampleHistory,
+		Thresholds:       thresholds(cfg.HWInfo),
+		Notifier:         notifier(cfg.Discord),
+		Resolver:         resolver,
+		IgnoreInterfaces: cfg.HWInfo.NetIgnore,
+	})
+	metrics.Registry.MustRegister(metrics.NewSystemCollector(sampler.Latest))
+
+	// device list กับการแจ้งเตือนเปลี่ยนได้เลยไม่ต้อง restart
+	store.OnReload(func(cfg *config.Config) {
+		sampler.SetThresholds(thresholds(cfg.HWInfo))
+		sampler.SetNotifier(notifier(cfg.Discord))
 	})
+	store.Watch()
+
+	devices := func() []events.Device {
+		return append(light.LightHandler{Config: store}.Devices(), valve.ValveHandler{Config: store}.Devices()...)
+	}
+	hub := events.NewHub(cfg.App.EventsBuffer, devices)
+	notify := func(title, description string) {
+		if n := notifier(store.Get().Discord); n != nil {
+			if err := n.SendMessage(discordbot.ThePayload{Embeds: []discordbot.Embed{{Title: title, Description: description}}}); err != nil {
+				log.Println("[discord] failed to send notification:", err)
+			}
+		}
+	}
 
-	appPort := viper.GetString("APP_PORT")
-	if appPort == "" {
-		appPort = "5000"
+	var embeddedBroker *mqttbroker.Broker
+	if cfg.MQTT.Embedded {
+		ledger, err := mqttbroker.LoadLedger(cfg.MQTT.EmbeddedAuth)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		mqttbroker.AllowAll(ledger, cfg.MQTT.Username, cfg.MQTT.Password)
+		embeddedBroker, err = mqttbroker.New(mqttbroker.Options{
+			Addr:   cfg.MQTT.EmbeddedAddr,
+			WSAddr: cfg.MQTT.EmbeddedWSAddr,
+			Ledger: ledger,
+		})
+		if err == nil {
+			err = embeddedBroker.Start()
+		}
+		if err != nil {
+			log.Fatalln("[broker]", err)
+		}
 	}
 
-	var broker = viper.GetString("BROKER")
-	var port = 1883
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", broker, port))
-	opts.SetClientID(viper.GetString("CLIENT_ID"))
-	opts.SetUsername(viper.GetString("USERNAME"))
-	opts.SetPassword(viper.GetString("PASSWORD"))
+	statusTopic := cfg.MQTT.StatusTopic
+	opts, err := mqttconn.Options(cfg.MQTT)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	opts.SetDefaultPublishHandler(messagePubHandler)
 	opts.SetAutoReconnect(true)
 	opts.SetResumeSubs(true)
-	opts.OnConnect = connectHandler
+	// broker ประกาศ offline ให้เองถ้าเราหลุดไปแบบไม่ได้ disconnect
+	opts.SetWill(statusTopic, "offline", 1, true)
+	opts.OnConnect = func(client mqtt.Client) {
+		connectHandler(client)
+		client.Publish(statusTopic, 1, true, "online")
+		hub.Subscribe(transport.NewPaho(client))
+	}
 	opts.OnConnectionLost = connectLostHandler
+	mqttMonitor := mqttconn.NewMonitor(cfg.MQTT.URLs())
+	mqttMonitor.Attach(opts)
+	mqttMonitor.Notify = notify
 	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+
+	lightHandler := light.LightHandler{
+		MqttClient: transport.NewPaho(client),
+		Config:     store,
+	}
+	valveHandler := valve.ValveHandler{
+		MqttClient: transport.NewPaho(client),
+		Config:     store,
+	}
+	commands := command.NewDispatcher(hub, lightHandler, valveHandler)
+	lightHandler.Commands = commands
+	valveHandler.Commands = commands
+	interlock := &safety.Interlock{
+		Config:   store,
+		Hub:      hub,
+		Commands: commands,
+		Audit:    safety.AuditLog{DB: db},
+		Notify:   notify,
+	}
+	commands.Guard(interlock)
+	lamps := &lamp.Tracker{
+		Config: store,
+		Hub:    hub,
+		Store:  lamp.Store{DB: db},
+		Notify: notify,
+	}
+	sequencer := &command.Sequencer{
+		Dispatcher: commands,
+		Hub:        hub,
+		Delay:      func() time.Duration { return store.Get().Safety.StaggerDelay },
+	}
+	sequenceHandler := command.SequenceHandler{Sequencer: sequencer}
+	batchHandler := command.BatchHandler{
+		Batch: &command.Batch{
+			Dispatcher:     commands,
+			Sequencer:      sequencer,
+			Hub:            hub,
+			Workers:        func() int { return store.Get().Safety.CommandWorkers },
+			ConfirmTimeout: func() time.Duration { return store.Get().Safety.CommandConfirmTimeout },
+		},
+		Lights: lightHandler.Lights,
+	}
+	lampHandler := lamp.Handler{Tracker: lamps}
+	commandThrottle := &throttle.Throttle{Config: store}
+	idempotencyKeys := &idempotency.Keys{DB: db, TTL: cfg.App.IdempotencyTTL}
+
+	automations := &rules.Engine{
+		Store:    rules.Store{DB: db},
+		Hub:      hub,
+		Commands: commands,
+		Devices:  devices,
+		Notify:   notify,
+	}
+
+	r := NewRouter(Services{
+		HeaderSecret: cfg.Auth.HeaderSecret,
+		Auth:         authHandler,
+		Host:         hwClient.Host,
+		Sampler:      sampler,
+		MQTT:         mqttMonitor,
+		Hub:          hub,
+		Commands:     commands,
+		Lights:       lightHandler,
+		Valves:       valveHandler,
+		Sequences:    sequenceHandler,
+		Batch:        batchHandler,
+		Lamps:        lampHandler,
+		Throttle:     commandThrottle,
+		Idempotency:  idempotencyKeys,
+		Rules:        automations,
+		Interlock:    interlock,
+	})
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.App.Port),
+		Handler: r,
+	}
+	// SSE/WebSocket streams never finish on their own, end them so Shutdown
+	// only waits for real requests such as valve commands
+	server.RegisterOnShutdown(hub.Close)
+
+	app := lifecycle.New(server, cfg.App.ShutdownTimeout)
+	app.Go(sampler.Run)
+	app.Go(interlock.Run)
+	app.Go(lamps.Run)
+	app.Go(idempotencyKeys.Run)
+	app.Go(automations.Run)
+	app.Go(func(ctx context.Context) {
+		mqttMonitor.Connect(ctx, client, time.Second, time.Minute)
+	})
+	// ยกเลิก sequence ที่ค้างก่อนตัด MQTT
+	app.OnStop("sequences", sequencer.Close)
+	app.OnStop("mqtt", func(ctx context.Context) error {
+		if !client.IsConnected() {
+			client.Disconnect(0)
+			return nil
+		}
+		token := client.Publish(statusTopic, 1, true, "offline")
+		select {
+		case <-token.Done():
+		case <-ctx.Done():
+		}
+		client.Disconnect(250)
+		return token.Error()
+	})
+	if embeddedBroker != nil {
+		// หลัง client ของเรา disconnect แล้วค่อยปิด broker
+		app.OnStop("mqtt broker", func(context.Context) error {
+			return embeddedBroker.Close()
+		})
+	}
+	if db != nil {
+		app.OnStop("database", func(context.Context) error {
+			db.Close()
+			return nil
+		})
 	}
 
-	r.Mount("/light", LightRoutes(client))
-	r.Mount("/valve", ValveRoutes(client))
+	log.Printf("HTTP server listening on port %s", cfg.App.Port)
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	log.Printf("HTTP server listening on port %s", appPort)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", appPort), r))
+// Services is what NewRouter hangs the routes on
+type Services struct {
+	HeaderSecret string
+	Auth         auth.AuthHandler
+	Host         hwinfo.HostInfo
+	Sampler      *hwinfo.Sampler
+	MQTT         *mqttconn.Monitor
+	Hub          *events.Hub
+	Commands     *command.Dispatcher
+	Lights       light.LightHandler
+	Valves       valve.ValveHandler
+	Sequences    command.SequenceHandler
+	Batch        command.BatchHandler
+	Lamps        lamp.Handler
+	Throttle     *throttle.Throttle
+	Idempotency  *idempotency.Keys
+	Rules        *rules.Engine
+	Interlock    *safety.Interlock
 }
 
-func LightRoutes(mqttClient mqtt.Client) chi.Router {
+func NewRouter(s Services) chi.Router {
+	r := chi.NewRouter()
+	// ?token= ของ WebSocket/SSE ไม่ลง access log
+	r.Use(auth.RedactToken(middleware.Logger))
+	r.Use(metrics.Middleware)
+	r.Use(middleware.Recoverer)
+	r.NotFound(response.NotFound)
+	r.MethodNotAllowed(response.MethodNotAllowed)
+
+	// /metrics อยู่นอก AuthMiddleware เพื่อให้ Prometheus scrape ได้
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	// หน้าเว็บเป็นไฟล์ static ล้วน ข้อมูลจริงต้อง login ก่อน
+	r.Handle("/ui/*", http.StripPrefix("/ui", web.Handler()))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+	r.Get("/openapi.json", openapi.Handler)
+	r.Get("/docs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ui/docs.html", http.StatusFound)
+	})
+	r.Post("/auth/login", s.Auth.Login)
+
+	// health ไม่ต้อง login ให้ load balancer เรียกได้
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		health := Health{Status: "ok", MQTT: s.MQTT.Status()}
+		status := http.StatusOK
+		if !health.MQTT.Connected {
+			health.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		}
+		response.JSON(w, r, status, health)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(AuthMiddleware(s.HeaderSecret, s.Auth.Authenticator.Sessions))
+		// retry ที่มี Idempotency-Key เดิมได้คำตอบเดิม ไม่สั่งซ้ำ
+		r.Use(s.Idempotency.Middleware)
+
+		r.Post("/auth/logout", s.Auth.Logout)
+		r.Get("/auth/me", s.Auth.Me)
+
+		// stream ยาว ห้ามโดน Timeout
+		r.Get("/events", events.EventsHandler{Hub: s.Hub}.Stream)
+		r.Get("/ws", ws.Handler{Hub: s.Hub, Commands: s.Commands, Throttle: s.Throttle}.Serve)
+		r.Get("/events/recent", events.EventsHandler{Hub: s.Hub}.Recent)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(1 * time.Minute))
+
+			r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+				render.JSON(w, r, response.HTTPResponse{
+					Data:  s.Host,
+					Error: nil,
+				})
+			})
+
+			r.Get("/devices", func(w http.ResponseWriter, r *http.Request) {
+				render.JSON(w, r, response.HTTPResponse{
+					Data:  append(s.Lights.Devices(), s.Valves.Devices()...),
+					Error: nil,
+				})
+			})
+			r.With(s.MQTT.Require).Post("/devices/sequences", s.Sequences.Start)
+			r.Get("/devices/sequences/{id}", s.Sequences.Get)
+			r.Delete("/devices/sequences/{id}", s.Sequences.Cancel)
+			r.With(s.MQTT.Require, s.Throttle.Batch(s.Batch.Targets)).Post("/devices/commands", s.Batch.Commands)
+
+			// ชั่วโมงหลอดไม่ต้องรอ MQTT
+			r.Get("/light/{light}/maintenance", s.Lamps.Maintenance)
+			r.Post("/light/{light}/maintenance/replacements", s.Lamps.Replace)
+			r.With(s.MQTT.Require).Mount("/light", LightRoutes(s.Lights, s.Batch, s.Throttle))
+			r.With(s.MQTT.Require).Mount("/valve", ValveRoutes(s.Valves, s.Throttle))
+			r.Mount("/system", SystemRoutes(s.Sampler))
+			r.Mount("/rules", RulesRoutes(rules.Handler{Engine: s.Rules}))
+			r.Get("/safety/overrides", s.Interlock.Audit.Overrides)
+		})
+	})
+	return r
+}
+
+func LightRoutes(lightHandler light.LightHandler, batchHandler command.BatchHandler, commandThrottle *throttle.Throttle) chi.Router {
 	r := chi.NewRouter() // สร้าง router ใหม่
-	lightHandler := light.LightHandler{
-		MqttClient: mqttClient,
-	}
 
 	r.Get("/{light}", lightHandler.Light)
 	r.Get("/lights", lightHandler.GetAllLights)
-	r.Put("/{light}/{action}", lightHandler.UpdateLight)
+	r.With(commandThrottle.Commands("light")).Put("/{light}/{action}", lightHandler.UpdateLight)
+	r.With(commandThrottle.Batch(batchHandler.LightTargets)).Put("/all/{action}", batchHandler.AllLights)
 	return r
 }
 
-func ValveRoutes(mqttClient mqtt.Client) chi.Router {
+func ValveRoutes(valveHandler valve.ValveHandler, commandThrottle *throttle.Throttle) chi.Router {
 	r := chi.NewRouter() // สร้าง router ใหม่
-	valveHandler := valve.ValveHandler{
-		MqttClient: mqttClient,
-	}
 
 	r.Get("/{valve}", valveHandler.Valve)
-	r.Put("/{valve}/{action}", valveHandler.UpdateValve)
+	r.With(commandThrottle.Commands("valve")).Put("/{valve}/{action}", valveHandler.UpdateValve)
+	return r
+}
+
+func SystemRoutes(sampler *hwinfo.Sampler) chi.Router {
+	r := chi.NewRouter()
+	systemHandler := hwinfo.SystemHandler{
+		Sampler: sampler,
+	}
+
+	r.Get("/", systemHandler.System)
+	r.Get("/reports", systemHandler.Reports)
 	return r
 }
+
+func RulesRoutes(rulesHandler rules.Handler) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", rulesHandler.List)
+	r.Post("/", rulesHandler.Create)
+	r.Post("/dry-run", rulesHandler.DryRun)
+	r.Get("/{id}", rulesHandler.Get)
+	r.Put("/{id}", rulesHandler.Update)
+	r.Delete("/{id}", rulesHandler.Delete)
+	r.Get("/{id}/runs", rulesHandler.Runs)
+	return r
+}
+
+func thresholds(cfg config.HWInfoConfig) hwinfo.Thresholds {
+	return hwinfo.Thresholds{
+		DiskPercent:        cfg.DiskAlertPercent,
+		MemoryPercent:      cfg.MemoryAlertPercent,
+		TemperatureCelsius: cfg.TemperatureAlertC,
+		Hysteresis:         cfg.AlertHysteresis,
+		RecentBoot:         cfg.RecentBoot,
+	}
+}
+
+func notifier(cfg config.DiscordConfig) hwinfo.Notifier {
+	if !cfg.Enabled() {
+		return nil
+	}
+	discordClient := discordbot.NewDiscordClient(cfg.WebhookID, cfg.WebhookToken, false, nil)
+	return &discordClient
+}
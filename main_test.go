@@ -0,0 +1,23 @@
+package main
+
+import (
+	"Panong/iot/safety"
+	"Panong/pkg/openapi"
+	"testing"
+)
+
+// unspecified are routes left out of openapi.json on purpose
+var unspecified = []string{"/", "/ui/*", "/docs"}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	// handlers are only wired up, never called, so zero values are enough
+	r := NewRouter(Services{Interlock: &safety.Interlock{}})
+
+	problems, err := openapi.Verify(r, unspecified...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"Panong/pkg/response"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrNoDatabase         = errors.New("login is not available without a database")
+)
+
+func init() {
+	response.RegisterError(ErrInvalidCredentials, http.StatusUnauthorized, response.CodeUnauthorized)
+	response.RegisterError(ErrNoDatabase, http.StatusServiceUnavailable, response.CodeServiceUnavailable)
+}
+
+// User is whoever made the request. The shared X-Auth-Token secret maps to
+// the System user, which has ID 0 and is not an admin: only users who log in
+// can force commands or read the override log.
+type User struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+var System = User{ID: 0, Name: "system"}
+
+type Session struct {
+	Token     string    `json:"token"`
+	User      User      `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sessions keeps login sessions in memory; a restart logs everyone out.
+type Sessions struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	if ttl <= 0 {
+		ttl = 12 * time.Hour
+	}
+	return &Sessions{
+		ttl:      ttl,
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s *Sessions) Create(user User) (Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return Session{}, err
+	}
+	session := Session{
+		Token:     hex.EncodeToString(buf),
+		User:      user,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// เก็บกวาด session หมดอายุไปด้วยเลย
+	for token, old := range s.sessions {
+		if time.Now().After(old.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+func (s *Sessions) Get(token string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return Session{}, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, token)
+		return Session{}, false
+	}
+	return session, true
+}
+
+func (s *Sessions) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// Authenticator checks email/phone + password against the users table.
+// Hashes are bcrypt of password+Pepper.
+type Authenticator struct {
+	DB       *pgxpool.Pool
+	Pepper   string
+	Sessions *Sessions
+}
+
+func (a Authenticator) Login(ctx context.Context, identifier, password string) (Session, error) {
+	if a.DB == nil {
+		return Session{}, ErrNoDatabase
+	}
+
+	var (
+		user User
+		hash string
+	)
+	query := `
+    SELECT id, COALESCE(email, phone_number, ''), password_hash, admin
+    FROM users
+    WHERE (email = $1 OR phone_number = $1) AND deleted_at IS NULL
+    LIMIT 1;
+    `
+	err := a.DB.QueryRow(ctx, query, identifier).Scan(&user.ID, &user.Name, &hash, &user.Admin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Session{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+a.Pepper)); err != nil {
+		return Session{}, ErrInvalidCredentials
+	}
+	return a.Sessions.Create(user)
+}
+
+type ctxKey struct{}
+
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, ctxKey{}, user)
+}
+
+// FromContext returns the authenticated user, or false outside AuthMiddleware
+func FromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(ctxKey{}).(User)
+	return user, ok
+}
+
+// ClientKey says whose rate limits and Idempotency-Keys a request counts
+// against: the logged-in user, or for the shared secret, which every app and
+// script holds, the address the request comes from.
+func ClientKey(r *http.Request) string {
+	if user, ok := FromContext(r.Context()); ok && user.ID != System.ID {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "secret:" + host
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"Panong/pkg/response"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+type LoginRequest struct {
+	// Email or phone number
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type AuthHandler struct {
+	Authenticator Authenticator
+}
+
+func (h AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil || req.Username == "" || req.Password == "" {
+		response.BadRequest(w, r, "username and password required")
+		return
+	}
+
+	session, err := h.Authenticator.Login(r.Context(), strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.OK(w, r, session)
+}
+
+func (h AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	h.Authenticator.Sessions.Delete(TokenFromRequest(r))
+	response.OK(w, r, "logged out")
+}
+
+func (h AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	user, _ := FromContext(r.Context())
+	response.OK(w, r, user)
+}
+
+// TokenFromRequest reads X-Auth-Token or a Bearer token. Browsers can't set
+// headers on WebSocket or EventSource requests: a WebSocket may offer the
+// token as a "token.<token>" subprotocol, and either may pass ?token=.
+func TokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get("X-Auth-Token"); token != "" {
+		return token
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	upgrade := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	if upgrade {
+		for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+			for _, protocol := range strings.Split(header, ",") {
+				if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), TokenProtocolPrefix); ok {
+					return token
+				}
+			}
+		}
+	}
+	stream := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if upgrade || stream {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// TokenProtocolPrefix marks the WebSocket subprotocol that carries the token
+const TokenProtocolPrefix = "token."
+
+type originalURL struct{}
+
+// RedactToken wraps an access logger so it logs ?token= as "REDACTED"; the
+// handlers behind it still get the real URL.
+func RedactToken(logger func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		logged := logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if original, ok := r.Context().Value(originalURL{}).(*http.Request); ok {
+				r.URL, r.RequestURI = original.URL, original.RequestURI
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if !query.Has("token") {
+				logged.ServeHTTP(w, r)
+				return
+			}
+			query.Set("token", "REDACTED")
+			redacted := r.WithContext(context.WithValue(r.Context(), originalURL{}, r))
+			u := *r.URL
+			u.RawQuery = query.Encode()
+			redacted.URL, redacted.RequestURI = &u, u.RequestURI()
+			logged.ServeHTTP(w, redacted)
+		})
+	}
+}
@@ -0,0 +1,617 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Config is every setting the server reads. Keys are the .env / environment
+// variable names; any key can instead be read from a file named by KEY_FILE,
+// e.g. HEADER_SECRET_AUTH_FILE=/run/secrets/header_secret.
+type Config struct {
+	App       AppConfig       `mapstructure:",squash"`
+	MQTT      MQTTConfig      `mapstructure:",squash"`
+	Auth      AuthConfig      `mapstructure:",squash"`
+	Lights    LightsConfig    `mapstructure:",squash"`
+	Valves    ValvesConfig    `mapstructure:",squash"`
+	Discord   DiscordConfig   `mapstructure:",squash"`
+	HWInfo    HWInfoConfig    `mapstructure:",squash"`
+	Safety    SafetyConfig    `mapstructure:",squash"`
+	Lamps     LampsConfig     `mapstructure:",squash"`
+	RateLimit RateLimitConfig `mapstructure:",squash"`
+}
+
+type AppConfig struct {
+	Port            string        `mapstructure:"app_port"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	EventsBuffer    int           `mapstructure:"events_buffer"`
+	// IdempotencyTTL is how long an Idempotency-Key answer is replayed
+	IdempotencyTTL time.Duration `mapstructure:"idempotency_ttl"`
+}
+
+type MQTTConfig struct {
+	Broker string `mapstructure:"broker"`
+	Port   int    `mapstructure:"mqtt_port"`
+	// Brokers are full URLs tried in order for failover, e.g.
+	// ssl://mqtt.example.com:8883,wss://mqtt.example.com/mqtt. When set they
+	// replace Broker and Port.
+	Brokers     []string `mapstructure:"mqtt_brokers"`
+	ClientID    string   `mapstructure:"client_id"`
+	Username    string   `mapstructure:"username"`
+	Password    string   `mapstructure:"password"`
+	StatusTopic string   `mapstructure:"mqtt_status_topic"`
+
+	// CACert is a PEM bundle to trust instead of the system roots.
+	// ClientCert/ClientKey enable mutual TLS.
+	CACert      string `mapstructure:"mqtt_ca_cert"`
+	ClientCert  string `mapstructure:"mqtt_client_cert"`
+	ClientKey   string `mapstructure:"mqtt_client_key"`
+	TLSInsecure bool   `mapstructure:"mqtt_tls_insecure"`
+
+	// Embedded runs a broker in-process. Our client logs in to it with
+	// Username/Password; other clients come from the EmbeddedAuth ledger.
+	Embedded       bool   `mapstructure:"mqtt_embedded"`
+	EmbeddedAddr   string `mapstructure:"mqtt_embedded_addr"`
+	EmbeddedWSAddr string `mapstructure:"mqtt_embedded_ws_addr"`
+	EmbeddedAuth   string `mapstructure:"mqtt_embedded_auth"`
+}
+
+// BrokerSchemes are the URL schemes paho can dial
+var BrokerSchemes = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}
+
+// URLs returns the broker URLs to try, in order.
+func (m MQTTConfig) URLs() []string {
+	var urls []string
+	for _, b := range m.Brokers {
+		if b = strings.TrimSpace(b); b != "" {
+			urls = append(urls, b)
+		}
+	}
+	if len(urls) == 0 && m.Broker != "" {
+		urls = append(urls, fmt.Sprintf("tcp://%s:%d", m.Broker, m.Port))
+	}
+	if len(urls) == 0 && m.Embedded {
+		if _, port, err := net.SplitHostPort(m.EmbeddedAddr); err == nil {
+			urls = append(urls, "tcp://127.0.0.1:"+port)
+		}
+	}
+	return urls
+}
+
+type AuthConfig struct {
+	HeaderSecret   string        `mapstructure:"header_secret_auth"`
+	PSQLConnection string        `mapstructure:"psql_connection"`
+	PasswordPepper string        `mapstructure:"password_pepper"`
+	SessionTTL     time.Duration `mapstructure:"session_ttl"`
+}
+
+// LightsConfig maps each field light to its zigbee2mqtt ID. The order is the
+// order light.LightHandler matches friendly names in.
+type LightsConfig struct {
+	First         string `mapstructure:"first_light"`
+	Second        string `mapstructure:"second_light"`
+	Third         string `mapstructure:"third_light"`
+	ClubhouseLogo string `mapstructure:"in_front_of_clubhouse_logo_light"`
+}
+
+func (l LightsConfig) IDs() []string {
+	return []string{l.First, l.Second, l.Third, l.ClubhouseLogo}
+}
+
+type ValvesConfig struct {
+	Water string `mapstructure:"water_valve"`
+}
+
+func (v ValvesConfig) IDs() []string {
+	return []string{v.Water}
+}
+
+type DiscordConfig struct {
+	WebhookID    string `mapstructure:"discord_webhook_id"`
+	WebhookToken string `mapstructure:"discord_webhook_token"`
+}
+
+func (d DiscordConfig) Enabled() bool {
+	return d.WebhookID != "" && d.WebhookToken != ""
+}
+
+type HWInfoConfig struct {
+	SampleInterval      time.Duration `mapstructure:"hwinfo_sample_interval"`
+	SampleHistory       int           `mapstructure:"hwinfo_sample_history"`
+	DiskAlertPercent    float64       `mapstructure:"hwinfo_disk_alert_percent"`
+	MemoryAlertPercent  float64       `mapstructure:"hwinfo_memory_alert_percent"`
+	TemperatureAlertC   float64       `mapstructure:"hwinfo_temperature_alert_celsius"`
+	AlertHysteresis     float64       `mapstructure:"hwinfo_alert_hysteresis"`
+	RecentBoot          time.Duration `mapstructure:"hwinfo_recent_boot"`
+	NetIgnore           []string      `mapstructure:"hwinfo_net_ignore"`
+	PublicIPResolverURL string        `mapstructure:"public_ip_resolver_url"`
+}
+
+// SafetyConfig is enforced on every device command; 0 turns a limit off.
+type SafetyConfig struct {
+	MaxLightsOn int     `mapstructure:"max_lights_on"`
+	PowerBudget float64 `mapstructure:"power_budget_watts"`
+	// DeviceWatts rates each device as id=watts, e.g. light1=2000
+	DeviceWatts  []string      `mapstructure:"device_watts"`
+	MaxValveOpen time.Duration `mapstructure:"max_valve_open"`
+	// StaggerDelay spaces out ON commands sent together
+	StaggerDelay time.Duration `mapstructure:"stagger_delay"`
+	// CommandWorkers bounds how many commands of a bulk request are
+	// published at once, CommandConfirmTimeout how long it then waits for
+	// the devices to report their state
+	CommandWorkers        int           `mapstructure:"command_workers"`
+	CommandConfirmTimeout time.Duration `mapstructure:"command_confirm_timeout"`
+	// DeviceMinOff and DeviceMinOn are id=duration re-strike protection,
+	// e.g. light1=15m: how long a lamp must stay off before it may be
+	// switched on again, and on before it may be switched off
+	DeviceMinOff []string `mapstructure:"device_min_off"`
+	DeviceMinOn  []string `mapstructure:"device_min_on"`
+}
+
+// pairs splits id=value entries; malformed entries are reported by Validate
+// and skipped here.
+func pairs[T any](entries []string, parse func(string) (T, error)) map[string]T {
+	out := make(map[string]T)
+	for _, pair := range entries {
+		id, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if v, err := parse(strings.TrimSpace(value)); err == nil {
+			out[strings.TrimSpace(id)] = v
+		}
+	}
+	return out
+}
+
+func parseNumber(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func (s SafetyConfig) Watts() map[string]float64 {
+	return pairs(s.DeviceWatts, parseNumber)
+}
+
+func (s SafetyConfig) MinOff() map[string]time.Duration {
+	return pairs(s.DeviceMinOff, time.ParseDuration)
+}
+
+func (s SafetyConfig) MinOn() map[string]time.Duration {
+	return pairs(s.DeviceMinOn, time.ParseDuration)
+}
+
+// LampsConfig drives the lamp-hour maintenance reminders
+type LampsConfig struct {
+	// RatedHours is the rated lamp life as id=hours, e.g. light1=6000; lights
+	// without one are counted but never reminded about
+	RatedHours []string `mapstructure:"lamp_rated_hours"`
+	// ReminderPercent of the rated life sends the first reminder, reaching
+	// the rated life sends another
+	ReminderPercent float64 `mapstructure:"lamp_reminder_percent"`
+}
+
+func (l LampsConfig) Rated() map[string]float64 {
+	return pairs(l.RatedHours, parseNumber)
+}
+
+// RateLimitConfig throttles PUT /light and /valve commands; a rate of 0
+// turns that limit off.
+type RateLimitConfig struct {
+	// UserRate and DeviceRate are commands a minute, each user and device
+	// may also send a burst of that many at once
+	UserRate    float64 `mapstructure:"command_user_rate"`
+	UserBurst   int     `mapstructure:"command_user_burst"`
+	DeviceRate  float64 `mapstructure:"command_device_rate"`
+	DeviceBurst int     `mapstructure:"command_device_burst"`
+	// CoalesceWindow answers the same command from the same user with the
+	// first result instead of publishing it again
+	CoalesceWindow time.Duration `mapstructure:"command_coalesce_window"`
+}
+
+// defaults also registers every key, so values that only exist in the
+// environment are picked up by Unmarshal.
+var defaults = map[string]any{
+	"app_port":         "5000",
+	"shutdown_timeout": "15s",
+	"events_buffer":    256,
+	"idempotency_ttl":  "24h",
+
+	"broker":            "",
+	"mqtt_port":         1883,
+	"client_id":         "",
+	"username":          "",
+	"password":          "",
+	"mqtt_status_topic": "panong/status",
+	"mqtt_brokers":      "",
+	"mqtt_ca_cert":      "",
+	"mqtt_client_cert":  "",
+	"mqtt_client_key":   "",
+	"mqtt_tls_insecure": false,
+
+	"mqtt_embedded":         false,
+	"mqtt_embedded_addr":    ":1883",
+	"mqtt_embedded_ws_addr": "",
+	"mqtt_embedded_auth":    "",
+
+	"header_secret_auth": "",
+	"psql_connection":    "",
+	"password_pepper":    "",
+	"session_ttl":        "12h",
+
+	"first_light":                      "",
+	"second_light":                     "",
+	"third_light":                      "",
+	"in_front_of_clubhouse_logo_light": "",
+	"water_valve":                      "",
+
+	"discord_webhook_id":    "",
+	"discord_webhook_token": "",
+
+	"hwinfo_sample_interval":           "1m",
+	"hwinfo_sample_history":            60,
+	"hwinfo_disk_alert_percent":        90,
+	"hwinfo_memory_alert_percent":      90,
+	"hwinfo_temperature_alert_celsius": 80,
+	"hwinfo_alert_hysteresis":          5,
+	"hwinfo_recent_boot":               "15m",
+	"hwinfo_net_ignore":                "docker,veth,br-",
+	"public_ip_resolver_url":           "",
+
+	"max_lights_on":           0,
+	"power_budget_watts":      0,
+	"device_watts":            "",
+	"max_valve_open":          "0s",
+	"stagger_delay":           "2s",
+	"command_workers":         4,
+	"command_confirm_timeout": "5s",
+	"device_min_off":          "",
+	"device_min_on":           "",
+
+	"lamp_rated_hours":      "",
+	"lamp_reminder_percent": 90,
+
+	"command_user_rate":       30,
+	"command_user_burst":      5,
+	"command_device_rate":     12,
+	"command_device_burst":    3,
+	"command_coalesce_window": "2s",
+}
+
+// Validate reports every problem at once so a bad deploy can be fixed in
+// one go.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(key, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	positive := func(key string, value time.Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration, got %s", key, value))
+		}
+	}
+	percent := func(key string, value float64) {
+		if value < 0 || value > 100 {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 100, got %g", key, value))
+		}
+	}
+
+	if port, err := strconv.Atoi(c.App.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("APP_PORT must be a port number, got %q", c.App.Port))
+	}
+	positive("SHUTDOWN_TIMEOUT", c.App.ShutdownTimeout)
+	positive("IDEMPOTENCY_TTL", c.App.IdempotencyTTL)
+
+	if len(c.MQTT.URLs()) == 0 {
+		errs = append(errs, errors.New("BROKER, MQTT_BROKERS or MQTT_EMBEDDED is required"))
+	}
+	if c.MQTT.Embedded {
+		if _, _, err := net.SplitHostPort(c.MQTT.EmbeddedAddr); err != nil {
+			errs = append(errs, fmt.Errorf("MQTT_EMBEDDED_ADDR must be host:port, got %q", c.MQTT.EmbeddedAddr))
+		}
+		if c.MQTT.Username == "" || c.MQTT.Password == "" {
+			errs = append(errs, errors.New("USERNAME and PASSWORD are required with MQTT_EMBEDDED, the embedded broker refuses anonymous clients"))
+		}
+	}
+	if c.MQTT.Port < 1 || c.MQTT.Port > 65535 {
+		errs = append(errs, fmt.Errorf("MQTT_PORT must be a port number, got %d", c.MQTT.Port))
+	}
+	for _, raw := range c.MQTT.URLs() {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || !slices.Contains(BrokerSchemes, u.Scheme) {
+			errs = append(errs, fmt.Errorf("MQTT_BROKERS: %q must look like scheme://host:port with scheme one of %s", raw, strings.Join(BrokerSchemes, ", ")))
+		}
+	}
+	if (c.MQTT.ClientCert == "") != (c.MQTT.ClientKey == "") {
+		errs = append(errs, errors.New("MQTT_CLIENT_CERT and MQTT_CLIENT_KEY must be set together"))
+	}
+	required("MQTT_STATUS_TOPIC", c.MQTT.StatusTopic)
+
+	required("HEADER_SECRET_AUTH", c.Auth.HeaderSecret)
+	positive("SESSION_TTL", c.Auth.SessionTTL)
+
+	required("FIRST_LIGHT", c.Lights.First)
+	required("SECOND_LIGHT", c.Lights.Second)
+	required("THIRD_LIGHT", c.Lights.Third)
+	required("IN_FRONT_OF_CLUBHOUSE_LOGO_LIGHT", c.Lights.ClubhouseLogo)
+	required("WATER_VALVE", c.Valves.Water)
+	seen := map[string]bool{}
+	for _, id := range append(c.Lights.IDs(), c.Valves.IDs()...) {
+		if id != "" && seen[id] {
+			errs = append(errs, fmt.Errorf("device ID %q is used twice", id))
+		}
+		seen[id] = true
+	}
+
+	if (c.Discord.WebhookID == "") != (c.Discord.WebhookToken == "") {
+		errs = append(errs, errors.New("DISCORD_WEBHOOK_ID and DISCORD_WEBHOOK_TOKEN must be set together"))
+	}
+
+	positive("HWINFO_SAMPLE_INTERVAL", c.HWInfo.SampleInterval)
+	percent("HWINFO_DISK_ALERT_PERCENT", c.HWInfo.DiskAlertPercent)
+	percent("HWINFO_MEMORY_ALERT_PERCENT", c.HWInfo.MemoryAlertPercent)
+	if c.HWInfo.AlertHysteresis < 0 {
+		errs = append(errs, fmt.Errorf("HWINFO_ALERT_HYSTERESIS must not be negative, got %g", c.HWInfo.AlertHysteresis))
+	}
+
+	if c.Safety.MaxLightsOn < 0 {
+		errs = append(errs, fmt.Errorf("MAX_LIGHTS_ON must not be negative, got %d", c.Safety.MaxLightsOn))
+	}
+	if c.Safety.PowerBudget < 0 {
+		errs = append(errs, fmt.Errorf("POWER_BUDGET_WATTS must not be negative, got %g", c.Safety.PowerBudget))
+	}
+	if c.Safety.MaxValveOpen < 0 {
+		errs = append(errs, fmt.Errorf("MAX_VALVE_OPEN must not be negative, got %s", c.Safety.MaxValveOpen))
+	}
+	devicePairs := func(key, unit string, entries []string, valid func(string) bool) {
+		for _, pair := range entries {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			id, value, ok := strings.Cut(pair, "=")
+			if !ok || !valid(strings.TrimSpace(value)) || !seen[strings.TrimSpace(id)] {
+				errs = append(errs, fmt.Errorf("%s: %q must be <device id>=<%s> for a configured device", key, pair, unit))
+			}
+		}
+	}
+	devicePairs("DEVICE_WATTS", "watts", c.Safety.DeviceWatts, func(s string) bool {
+		w, err := parseNumber(s)
+		return err == nil && w >= 0
+	})
+	duration := func(s string) bool {
+		d, err := time.ParseDuration(s)
+		return err == nil && d >= 0
+	}
+	devicePairs("DEVICE_MIN_OFF", "duration", c.Safety.DeviceMinOff, duration)
+	devicePairs("DEVICE_MIN_ON", "duration", c.Safety.DeviceMinOn, duration)
+	devicePairs("LAMP_RATED_HOURS", "hours", c.Lamps.RatedHours, func(s string) bool {
+		h, err := parseNumber(s)
+		return err == nil && h > 0
+	})
+	percent("LAMP_REMINDER_PERCENT", c.Lamps.ReminderPercent)
+
+	rateLimit := func(key string, rate float64, burst int) {
+		if rate < 0 {
+			errs = append(errs, fmt.Errorf("%s_RATE must not be negative, got %g", key, rate))
+		}
+		if rate > 0 && burst < 1 {
+			errs = append(errs, fmt.Errorf("%s_BURST must be at least 1, got %d", key, burst))
+		}
+	}
+	rateLimit("COMMAND_USER", c.RateLimit.UserRate, c.RateLimit.UserBurst)
+	rateLimit("COMMAND_DEVICE", c.RateLimit.DeviceRate, c.RateLimit.DeviceBurst)
+	if c.RateLimit.CoalesceWindow < 0 {
+		errs = append(errs, fmt.Errorf("COMMAND_COALESCE_WINDOW must not be negative, got %s", c.RateLimit.CoalesceWindow))
+	}
+	if c.Safety.StaggerDelay < 0 {
+		errs = append(errs, fmt.Errorf("STAGGER_DELAY must not be negative, got %s", c.Safety.StaggerDelay))
+	}
+	if c.Safety.CommandWorkers < 1 {
+		errs = append(errs, fmt.Errorf("COMMAND_WORKERS must be at least 1, got %d", c.Safety.CommandWorkers))
+	}
+	if c.Safety.CommandConfirmTimeout < 0 {
+		errs = append(errs, fmt.Errorf("COMMAND_CONFIRM_TIMEOUT must not be negative, got %s", c.Safety.CommandConfirmTimeout))
+	}
+	if c.Safety.PowerBudget > 0 && len(c.Safety.Watts()) == 0 {
+		errs = append(errs, errors.New("POWER_BUDGET_WATTS needs DEVICE_WATTS"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Store holds the current Config. Readers call Get on every use so reloaded
+// values take effect without a restart.
+type Store struct {
+	v   *viper.Viper
+	cur atomic.Pointer[Config]
+
+	mu    sync.Mutex
+	hooks []func(*Config)
+}
+
+// Load reads path (default .env, override with CONFIG_FILE) plus the
+// environment. A missing file is fine as long as the environment has what
+// Validate needs.
+func Load() (*Store, error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = ".env"
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("env")
+	v.AutomaticEnv()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("config: can't read %s: %w", path, err)
+		}
+	} else {
+		log.Printf("[config] %s not found, using environment only", path)
+	}
+
+	cfg, err := decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{v: v}
+	s.cur.Store(cfg)
+	return s, nil
+}
+
+// decode reads v into a Config. *_FILE secrets are read again every time and
+// go into a throwaway layer over v, so a reload sees a changed secret file
+// and v itself keeps only what came from the file and the environment.
+func decode(v *viper.Viper) (*Config, error) {
+	layer := viper.New()
+	for key, value := range v.AllSettings() {
+		layer.Set(key, value)
+	}
+	for key := range defaults {
+		file := v.GetString(key + "_file")
+		if file == "" {
+			continue
+		}
+		secret, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s_FILE: %w", strings.ToUpper(key), err)
+		}
+		layer.Set(key, strings.TrimSpace(string(secret)))
+	}
+
+	var cfg Config
+	if err := layer.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+func (s *Store) Get() *Config {
+	return s.cur.Load()
+}
+
+// OnReload registers fn to be called with the new Config after a reload.
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, fn)
+}
+
+// Watch reloads the config file whenever it changes. These apply live:
+//   - the device list
+//   - the Discord webhook
+//   - the hwinfo alert thresholds and hysteresis
+//   - the safety settings (limits, stagger delay, re-strike times, bulk
+//     command workers and confirm timeout)
+//   - lamp rated hours and the reminder percent
+//   - the command rate limits and coalesce window
+//
+// Other changes are logged and wait for a restart. An invalid file keeps the
+// previous Config. *_FILE secrets are read again on every reload, but only
+// the config file itself is watched.
+func (s *Store) Watch() {
+	if s.v.ConfigFileUsed() == "" {
+		return
+	}
+	if _, err := os.Stat(s.v.ConfigFileUsed()); err != nil {
+		return
+	}
+	s.v.OnConfigChange(func(fsnotify.Event) {
+		s.reload()
+	})
+	s.v.WatchConfig()
+}
+
+func (s *Store) reload() {
+	next := s.apply()
+	if next == nil {
+		return
+	}
+	// hook ช้าหรือเรียก OnReload เองก็ไม่ค้าง lock
+	s.mu.Lock()
+	hooks := slices.Clone(s.hooks)
+	s.mu.Unlock()
+	for _, fn := range hooks {
+		fn(next)
+	}
+}
+
+// apply decodes the file again and stores the live settings. It returns the
+// new Config, or nil when nothing live changed or the file is invalid.
+func (s *Store) apply() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fresh, err := decode(s.v)
+	if err != nil {
+		log.Println("[config] reload rejected, keeping the previous config:", err)
+		return nil
+	}
+
+	old := s.Get()
+	next := *old
+	next.Lights = fresh.Lights
+	next.Valves = fresh.Valves
+	next.Discord = fresh.Discord
+	next.HWInfo.DiskAlertPercent = fresh.HWInfo.DiskAlertPercent
+	next.HWInfo.MemoryAlertPercent = fresh.HWInfo.MemoryAlertPercent
+	next.HWInfo.TemperatureAlertC = fresh.HWInfo.TemperatureAlertC
+	next.HWInfo.AlertHysteresis = fresh.HWInfo.AlertHysteresis
+	next.Safety = fresh.Safety
+	next.Lamps = fresh.Lamps
+	next.RateLimit = fresh.RateLimit
+
+	if changed := diff(next, *fresh); len(changed) > 0 {
+		log.Printf("[config] restart to apply: %s", strings.Join(changed, ", "))
+	}
+	if reflect.DeepEqual(next, *old) {
+		return nil
+	}
+
+	s.cur.Store(&next)
+	log.Println("[config] reloaded")
+	return &next
+}
+
+// diff lists the keys whose values differ between a and b.
+func diff(a, b Config) []string {
+	var changed []string
+	var walk func(a, b reflect.Value)
+	walk = func(a, b reflect.Value) {
+		for i := range a.NumField() {
+			field := a.Type().Field(i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(a.Field(i), b.Field(i))
+				continue
+			}
+			if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+				changed = append(changed, strings.ToUpper(field.Tag.Get("mapstructure")))
+			}
+		}
+	}
+	walk(reflect.ValueOf(a), reflect.ValueOf(b))
+	return changed
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testEnv = `BROKER=localhost
+HEADER_SECRET_AUTH=secret
+FIRST_LIGHT=light1
+SECOND_LIGHT=light2
+THIRD_LIGHT=light3
+IN_FRONT_OF_CLUBHOUSE_LOGO_LIGHT=logo
+WATER_VALVE=water
+DEVICE_MIN_OFF=light1=15m,light2=10m
+`
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStore(t *testing.T, extra string) *Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.env")
+	write(t, path, testEnv+extra)
+	t.Setenv("CONFIG_FILE", path)
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLoad(t *testing.T) {
+	s := testStore(t, "")
+	cfg := s.Get()
+	if cfg.App.ShutdownTimeout != 15*time.Second {
+		t.Errorf("SHUTDOWN_TIMEOUT = %s, want the 15s default", cfg.App.ShutdownTimeout)
+	}
+	if min := cfg.Safety.MinOff(); min["light1"] != 15*time.Minute || min["light2"] != 10*time.Minute {
+		t.Errorf("DEVICE_MIN_OFF = %v", min)
+	}
+}
+
+func TestSecretFileIsReadAgainOnReload(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "discord_token")
+	write(t, secret, "first\n")
+	s := testStore(t, "DISCORD_WEBHOOK_ID=123\nDISCORD_WEBHOOK_TOKEN_FILE="+secret+"\n")
+	if got := s.Get().Discord.WebhookToken; got != "first" {
+		t.Fatalf("token = %q, want first", got)
+	}
+
+	var reloaded []string
+	s.OnReload(func(cfg *Config) { reloaded = append(reloaded, cfg.Discord.WebhookToken) })
+	write(t, secret, "second\n")
+	s.reload()
+	if got := s.Get().Discord.WebhookToken; got != "second" {
+		t.Errorf("token after reload = %q, want second", got)
+	}
+	if len(reloaded) != 1 || reloaded[0] != "second" {
+		t.Errorf("hooks saw %v, want [second]", reloaded)
+	}
+
+	// ไม่มีอะไรเปลี่ยน hook ไม่ต้องถูกเรียก
+	s.reload()
+	if len(reloaded) != 1 {
+		t.Errorf("hooks ran %d times, want once", len(reloaded))
+	}
+
+	// back to a plain value: the secret read from the file must not stick
+	write(t, os.Getenv("CONFIG_FILE"), testEnv+"DISCORD_WEBHOOK_ID=123\nDISCORD_WEBHOOK_TOKEN=plain\n")
+	if err := s.v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s.reload()
+	if got := s.Get().Discord.WebhookToken; got != "plain" {
+		t.Errorf("token after dropping the _FILE = %q, want plain", got)
+	}
+}
+
+func TestReloadHooksRunWithoutTheLock(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "discord_token")
+	write(t, secret, "first")
+	s := testStore(t, "DISCORD_WEBHOOK_ID=123\nDISCORD_WEBHOOK_TOKEN_FILE="+secret+"\n")
+
+	s.OnReload(func(*Config) {
+		// a hook that registers another must not deadlock
+		s.OnReload(func(*Config) {})
+	})
+	write(t, secret, "second")
+
+	done := make(chan struct{})
+	go func() {
+		s.reload()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reload is stuck calling hooks")
+	}
+}
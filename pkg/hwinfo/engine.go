@@ -5,8 +5,12 @@ import (
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/process"
 	"net"
+	"os"
+	"runtime"
 	"strings"
 )
 
@@ -23,6 +27,7 @@ type HostInfo struct {
 	PlatformVer string `json:"platform_ver"`
 	KernelVer   string `json:"kernel_ver"`
 	UptimeHours uint64 `json:"uptime_hours"`
+	BootTime    uint64 `json:"boot_time"`
 }
 
 // CPUInfo represents CPU information
@@ -59,12 +64,37 @@ type NetworkInterface struct {
 	IPv6Addresses []string  `json:"ipv_6_addresses"`
 }
 
+// TemperatureInfo represents a single temperature sensor reading
+type TemperatureInfo struct {
+	Sensor  string  `json:"sensor"`
+	Celsius float64 `json:"celsius"`
+}
+
+// LoadInfo represents the 1, 5 and 15 minute load averages
+type LoadInfo struct {
+	Load1  float64 `json:"load_1"`
+	Load5  float64 `json:"load_5"`
+	Load15 float64 `json:"load_15"`
+}
+
+// ProcessInfo represents the server process itself
+type ProcessInfo struct {
+	PID        int32   `json:"pid"`
+	RSSMB      float64 `json:"rss_mb"`
+	Goroutines int     `json:"goroutines"`
+	OpenFDs    int32   `json:"open_fds"`
+}
+
 type SystemInfo struct {
-	Host    HostInfo           `json:"host"`
-	CPU     []CPUInfo          `json:"cpu"`
-	Memory  MemoryInfo         `json:"memory"`
-	Disks   []DiskInfo         `json:"disks"`
-	Network []NetworkInterface `json:"network"`
+	Host         HostInfo           `json:"host"`
+	CPU          []CPUInfo          `json:"cpu"`
+	CPUPercent   float64            `json:"cpu_percent"`
+	Load         LoadInfo           `json:"load"`
+	Temperatures []TemperatureInfo  `json:"temperatures"`
+	Memory       MemoryInfo         `json:"memory"`
+	Disks        []DiskInfo         `json:"disks"`
+	Network      []NetworkInterface `json:"network"`
+	Process      ProcessInfo        `json:"process"`
 }
 
 func NewSystemInfo() (SystemInfo, error) {
@@ -80,6 +110,12 @@ func NewSystemInfo() (SystemInfo, error) {
 func (si *SystemInfo) FetchData() error {
 	var err error
 
+	// เคลียร์ของเก่าก่อน ไม่งั้น refresh แล้ว slice จะซ้ำ
+	si.CPU = nil
+	si.Temperatures = nil
+	si.Disks = nil
+	si.Network = nil
+
 	// Get Host Information
 	if hostInfo, err := host.Info(); err == nil {
 		si.Host = HostInfo{
@@ -89,6 +125,7 @@ func (si *SystemInfo) FetchData() error {
 			PlatformVer: hostInfo.PlatformVersion,
 			KernelVer:   hostInfo.KernelVersion,
 			UptimeHours: hostInfo.Uptime / 3600,
+			BootTime:    hostInfo.BootTime,
 		}
 	}
 
@@ -103,6 +140,18 @@ func (si *SystemInfo) FetchData() error {
 		}
 	}
 
+	// CPUPercent is left to Sampler, it needs the previous reading
+
+	if avg, err := load.Avg(); err == nil {
+		si.Load = LoadInfo{
+			Load1:  avg.Load1,
+			Load5:  avg.Load5,
+			Load15: avg.Load15,
+		}
+	}
+
+	si.Temperatures = readTemperatures()
+
 	// Get Memory Information
 	if memInfo, err := mem.VirtualMemory(); err == nil {
 		si.Memory = MemoryInfo{
@@ -158,6 +207,20 @@ func (si *SystemInfo) FetchData() error {
 			si.Network = append(si.Network, netInterface)
 		}
 	}
+
+	// Get Process Information (ตัว server เอง)
+	si.Process = ProcessInfo{
+		PID:        int32(os.Getpid()),
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if proc, err := process.NewProcess(si.Process.PID); err == nil {
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			si.Process.RSSMB = float64(memInfo.RSS) / (1024 * 1024)
+		}
+		if fds, err := proc.NumFDs(); err == nil {
+			si.Process.OpenFDs = fds
+		}
+	}
 	return err
 }
 
@@ -166,12 +229,14 @@ func (si *SystemInfo) ToReports(refresh bool) []Report {
 		si.FetchData()
 	}
 
-	info := make([]Report, 5) // 5 sections: Host, CPU, Memory, Disk, Network
+	info := make([]Report, 7) // 7 sections: Host, CPU, Memory, Disk, Network, Temperature, Process
 	info[0].Topic = "Host Information"
 	info[1].Topic = "CPU Information"
 	info[2].Topic = "Memory Information"
 	info[3].Topic = "Disk Information"
 	info[4].Topic = "Network Interfaces"
+	info[5].Topic = "Temperature"
+	info[6].Topic = "Process"
 
 	info[0].Content = fmt.Sprintf(
 		"Hostname: %v\n"+
@@ -189,13 +254,24 @@ func (si *SystemInfo) ToReports(refresh bool) []Report {
 
 	var cpuDetails strings.Builder
 
-	fmt.Fprintf(&cpuDetails, "CPU Model (%d Threads): %v\n"+
-		"Cores: %v\n"+
-		"MHz: %v\n",
-		len(si.CPU),
-		si.CPU[0].Model,
-		si.CPU[0].Cores,
-		si.CPU[0].Mhz)
+	if len(si.CPU) == 0 {
+		cpuDetails.WriteString("No CPU information\n")
+	} else {
+		fmt.Fprintf(&cpuDetails, "CPU Model (%d Threads): %v\n"+
+			"Cores: %v\n"+
+			"MHz: %v\n",
+			len(si.CPU),
+			si.CPU[0].Model,
+			si.CPU[0].Cores,
+			si.CPU[0].Mhz)
+	}
+
+	fmt.Fprintf(&cpuDetails, "Usage: %.2f%%\n"+
+		"Load Average: %.2f %.2f %.2f\n",
+		si.CPUPercent,
+		si.Load.Load1,
+		si.Load.Load5,
+		si.Load.Load15)
 
 	info[1].Content = cpuDetails.String()
 
@@ -244,8 +320,27 @@ func (si *SystemInfo) ToReports(refresh bool) []Report {
 		for i := range iface.IPv6Addresses {
 			fmt.Fprintf(&netDetails, "IPv6 Address: %v\n", iface.IPv6Addresses[i])
 		}
-		info[4].Content = netDetails.String()
 	}
+	info[4].Content = netDetails.String()
+
+	var tempDetails strings.Builder
+	if len(si.Temperatures) == 0 {
+		tempDetails.WriteString("No temperature sensors")
+	}
+	for _, temp := range si.Temperatures {
+		fmt.Fprintf(&tempDetails, "%v: %.1f°C\n", temp.Sensor, temp.Celsius)
+	}
+	info[5].Content = tempDetails.String()
+
+	info[6].Content = fmt.Sprintf(
+		"PID: %v\n"+
+			"RSS: %.2f MB\n"+
+			"Goroutines: %v\n"+
+			"Open FDs: %v",
+		si.Process.PID,
+		si.Process.RSSMB,
+		si.Process.Goroutines,
+		si.Process.OpenFDs)
 
 	return info
 }
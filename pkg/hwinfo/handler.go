@@ -0,0 +1,111 @@
+package hwinfo
+
+import (
+	"Panong/pkg/discordbot"
+	"Panong/pkg/response"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/render"
+)
+
+func init() {
+	response.RegisterError(ErrNoSample, http.StatusServiceUnavailable, response.CodeServiceUnavailable)
+}
+
+type SystemHandler struct {
+	Sampler *Sampler
+}
+
+func (h SystemHandler) current(r *http.Request) (SystemInfo, error) {
+	if r.URL.Query().Get("refresh") == "true" {
+		return h.Sampler.Refresh()
+	}
+	si, err := h.Sampler.Latest()
+	if errors.Is(err, ErrNoSample) {
+		return h.Sampler.Refresh()
+	}
+	return si, err
+}
+
+func (h SystemHandler) System(w http.ResponseWriter, r *http.Request) {
+	si, err := h.current(r)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, response.HTTPResponse{
+		Data:  si,
+		Error: nil,
+	})
+}
+
+// Reports renders ToReports as ?format=text (default), markdown or discord.
+func (h SystemHandler) Reports(w http.ResponseWriter, r *http.Request) {
+	si, err := h.current(r)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	reports := si.ToReports(false)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "text":
+		render.PlainText(w, r, ReportsText(reports))
+	case "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(ReportsMarkdown(reports)))
+	case "discord":
+		render.JSON(w, r, response.HTTPResponse{
+			Data:  ReportsDiscord(reports),
+			Error: nil,
+		})
+	default:
+		response.BadRequest(w, r, fmt.Sprintf("unknown format %q", format))
+	}
+}
+
+func ReportsText(reports []Report) string {
+	var b strings.Builder
+	for i, report := range reports {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "== %s ==\n%s\n", report.Topic, strings.TrimSpace(report.Content))
+	}
+	return b.String()
+}
+
+func ReportsMarkdown(reports []Report) string {
+	var b strings.Builder
+	for _, report := range reports {
+		fmt.Fprintf(&b, "## %s\n\n```\n%s\n```\n\n", report.Topic, strings.TrimSpace(report.Content))
+	}
+	return b.String()
+}
+
+// ReportsDiscord turns each report into an embed. Discord caps descriptions
+// at 4096 characters so long sections (many interfaces) are truncated, on a
+// character boundary so names in Thai don't end in a broken rune.
+func ReportsDiscord(reports []Report) discordbot.ThePayload {
+	const (
+		maxDescription = 4096
+		cut            = "…\n```"
+	)
+	payload := discordbot.ThePayload{}
+	for _, report := range reports {
+		desc := "```\n" + strings.TrimSpace(report.Content) + "\n```"
+		if utf8.RuneCountInString(desc) > maxDescription {
+			desc = string([]rune(desc)[:maxDescription-utf8.RuneCountInString(cut)]) + cut
+		}
+		payload.Embeds = append(payload.Embeds, discordbot.Embed{
+			Title:       report.Topic,
+			Description: desc,
+		})
+	}
+	return payload
+}
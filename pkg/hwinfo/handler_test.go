@@ -0,0 +1,33 @@
+package hwinfo
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReportsDiscordTruncatesOnRunes(t *testing.T) {
+	for _, content := range []string{
+		strings.Repeat("a", 5000),
+		// ภาษาไทยใช้ 3 byte ต่อตัว ตัดตาม byte จะได้ rune ขาด
+		strings.Repeat("สวิตช์ ", 1000),
+		"x" + strings.Repeat("ไฟ", 3000),
+	} {
+		payload := ReportsDiscord([]Report{{Topic: "Network", Content: content}})
+		desc := payload.Embeds[0].Description
+		if !utf8.ValidString(desc) {
+			t.Errorf("description of %d bytes isn't valid UTF-8", len(content))
+		}
+		if n := utf8.RuneCountInString(desc); n > 4096 {
+			t.Errorf("description is %d characters, want at most 4096", n)
+		}
+		if !strings.HasSuffix(desc, "…\n```") {
+			t.Errorf("description ends with %q, want the truncation mark", desc[len(desc)-10:])
+		}
+	}
+
+	short := ReportsDiscord([]Report{{Topic: "Host", Content: "สวัสดี\n"}})
+	if got := short.Embeds[0].Description; got != "```\nสวัสดี\n```" {
+		t.Errorf("short description = %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package hwinfo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// IPResolver finds the address the box is seen from on the internet
+type IPResolver interface {
+	PublicIP(ctx context.Context) (string, error)
+}
+
+// HTTPResolver GETs URL and expects the address as the plain-text body, the
+// way api.ipify.org or icanhazip.com answer. Point URL at a local stand-in
+// when the box has no internet or for testing.
+type HTTPResolver struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h HTTPResolver) PublicIP(ctx context.Context) (string, error) {
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("public ip resolver returned %s", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 256))
+	if err != nil {
+		return "", err
+	}
+
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("public ip resolver returned %q", ip)
+	}
+	return ip, nil
+}
+
+// AddressChange lists the addresses of one interface before and after
+type AddressChange struct {
+	Interface string
+	Old       []string
+	New       []string
+}
+
+// DiffNetwork compares interface addresses between two samples. Interfaces
+// whose name starts with one of ignore (docker bridges, veths) are skipped.
+func DiffNetwork(old, new []NetworkInterface, ignore []string) []AddressChange {
+	addresses := func(list []NetworkInterface) map[string][]string {
+		out := make(map[string][]string)
+		for _, iface := range list {
+			if iface.Flags&net.FlagLoopback != 0 || ignored(iface.Name, ignore) {
+				continue
+			}
+			addrs := append(slices.Clone(iface.IPv4Addresses), iface.IPv6Addresses...)
+			slices.Sort(addrs)
+			out[iface.Name] = addrs
+		}
+		return out
+	}
+	before, after := addresses(old), addresses(new)
+
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []AddressChange
+	for _, name := range names {
+		if !slices.Equal(before[name], after[name]) {
+			changes = append(changes, AddressChange{
+				Interface: name,
+				Old:       before[name],
+				New:       after[name],
+			})
+		}
+	}
+	return changes
+}
+
+func ignored(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func describeAddresses(addrs []string) string {
+	if len(addrs) == 0 {
+		return "-"
+	}
+	return strings.Join(addrs, ", ")
+}
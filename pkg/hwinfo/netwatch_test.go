@@ -0,0 +1,187 @@
+package hwinfo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffNetwork(t *testing.T) {
+	eth0 := NetworkInterface{Name: "eth0", IPv4Addresses: []string{"192.168.1.20/24"}, IPv6Addresses: []string{"fe80::1/64"}}
+	wlan0 := NetworkInterface{Name: "wlan0", IPv4Addresses: []string{"192.168.1.30/24"}}
+	lo := NetworkInterface{Name: "lo", Flags: net.FlagLoopback, IPv4Addresses: []string{"127.0.0.1/8"}}
+	with := func(iface NetworkInterface, v4 ...string) NetworkInterface {
+		iface.IPv4Addresses = v4
+		return iface
+	}
+
+	for _, tc := range []struct {
+		name     string
+		old, new []NetworkInterface
+		ignore   []string
+		want     []AddressChange
+	}{
+		{
+			name: "nothing changed",
+			old:  []NetworkInterface{eth0, wlan0},
+			new:  []NetworkInterface{wlan0, eth0},
+		},
+		{
+			name: "interface added",
+			old:  []NetworkInterface{eth0},
+			new:  []NetworkInterface{eth0, wlan0},
+			want: []AddressChange{{Interface: "wlan0", New: []string{"192.168.1.30/24"}}},
+		},
+		{
+			name: "interface removed",
+			old:  []NetworkInterface{eth0, wlan0},
+			new:  []NetworkInterface{eth0},
+			want: []AddressChange{{Interface: "wlan0", Old: []string{"192.168.1.30/24"}}},
+		},
+		{
+			name: "address changed",
+			old:  []NetworkInterface{eth0, wlan0},
+			new:  []NetworkInterface{with(eth0, "192.168.1.21/24"), wlan0},
+			want: []AddressChange{{Interface: "eth0", Old: []string{"192.168.1.20/24", "fe80::1/64"}, New: []string{"192.168.1.21/24", "fe80::1/64"}}},
+		},
+		{
+			name: "same addresses in another order",
+			old:  []NetworkInterface{with(eth0, "10.0.0.1/8", "192.168.1.20/24")},
+			new:  []NetworkInterface{with(eth0, "192.168.1.20/24", "10.0.0.1/8")},
+		},
+		{
+			name:   "loopback and ignored interfaces",
+			old:    []NetworkInterface{eth0, lo},
+			new:    []NetworkInterface{eth0, with(lo, "127.0.0.2/8"), {Name: "veth12ab", IPv4Addresses: []string{"172.17.0.1/16"}}},
+			ignore: []string{"docker", "veth"},
+		},
+		{
+			name: "several changes sorted by name",
+			old:  []NetworkInterface{wlan0},
+			new:  []NetworkInterface{eth0},
+			want: []AddressChange{
+				{Interface: "eth0", New: []string{"192.168.1.20/24", "fe80::1/64"}},
+				{Interface: "wlan0", Old: []string{"192.168.1.30/24"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DiffNetwork(tc.old, tc.new, tc.ignore)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("DiffNetwork = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		want   string
+		err    string
+	}{
+		{name: "plain text", status: http.StatusOK, body: "203.0.113.7\n", want: "203.0.113.7"},
+		{name: "ipv6", status: http.StatusOK, body: "2001:db8::7", want: "2001:db8::7"},
+		{name: "server error", status: http.StatusServiceUnavailable, body: "busy", err: "returned 503 Service Unavailable"},
+		{name: "not an address", status: http.StatusOK, body: "<html>blocked</html>", err: `returned "<html>blocked</html>"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			ip, err := HTTPResolver{URL: srv.URL}.PublicIP(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("PublicIP = %q %v, want an error with %q", ip, err, tc.err)
+				}
+				return
+			}
+			if err != nil || ip != tc.want {
+				t.Fatalf("PublicIP = %q %v, want %q", ip, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestHTTPResolverTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := HTTPResolver{URL: srv.URL}.PublicIP(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PublicIP = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("PublicIP took %s after the context ran out", elapsed)
+	}
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	if _, err := (HTTPResolver{URL: srv.URL, Client: client}).PublicIP(context.Background()); err == nil {
+		t.Error("PublicIP with a client timeout succeeded against a hung server")
+	}
+}
+
+// a failed lookup keeps the last address instead of reporting a change
+func TestSamplerKeepsPublicIPWhenLookupFails(t *testing.T) {
+	var mu sync.Mutex
+	answer := "203.0.113.7"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if answer == "" {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	n := &notifier{}
+	s := NewSampler(SamplerOptions{Notifier: n, Resolver: HTTPResolver{URL: srv.URL}})
+	s.fetch = func() (SystemInfo, error) { return SystemInfo{}, nil }
+	set := func(ip string) {
+		mu.Lock()
+		answer = ip
+		mu.Unlock()
+		s.sample()
+	}
+
+	set("203.0.113.7")
+	set("")
+	set("203.0.113.7")
+	if len(n.sent) != 0 {
+		t.Errorf("sent %+v while the address never changed", n.sent)
+	}
+	for _, sample := range s.History() {
+		if sample.PublicIP != "203.0.113.7" {
+			t.Errorf("sample at %s has public ip %q", sample.At, sample.PublicIP)
+		}
+	}
+
+	set("198.51.100.9")
+	if len(n.sent) != 1 || n.sent[0].Title != "Public IP changed on " {
+		t.Errorf("sent %+v, want one public ip change", n.sent)
+	}
+}
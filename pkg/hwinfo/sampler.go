@@ -0,0 +1,331 @@
+package hwinfo
+
+import (
+	"Panong/pkg/discordbot"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+)
+
+var ErrNoSample = errors.New("no system info sampled yet")
+
+// Notifier is what the sampler needs from discordbot.DiscordClient
+type Notifier interface {
+	SendMessage(tp discordbot.ThePayload) error
+}
+
+// Thresholds decide when the sampler alerts. A zero percent disables that
+// check. An alert re-arms only once the value drops Hysteresis points below
+// its threshold, so a disk hovering around the limit doesn't spam Discord.
+type Thresholds struct {
+	DiskPercent   float64
+	MemoryPercent float64
+	// TemperatureCelsius uses the same hysteresis, read as degrees
+	TemperatureCelsius float64
+	Hysteresis         float64
+	// RecentBoot alerts on startup when the host has been up for less than
+	// this; the server runs on the same box so a reboot restarts us too.
+	RecentBoot time.Duration
+}
+
+type SamplerOptions struct {
+	Interval   time.Duration
+	History    int
+	Thresholds Thresholds
+	Notifier   Notifier
+	// Resolver is optional; without it only LAN addresses are watched
+	Resolver IPResolver
+	// IgnoreInterfaces are name prefixes left out of change detection
+	IgnoreInterfaces []string
+}
+
+type Sample struct {
+	At       time.Time  `json:"at"`
+	Info     SystemInfo `json:"info"`
+	PublicIP string     `json:"public_ip,omitempty"`
+}
+
+// Sampler refreshes SystemInfo on an interval and keeps the last few samples
+// in a ring buffer.
+type Sampler struct {
+	opts SamplerOptions
+	// fetch reads the system, NewSystemInfo outside tests
+	fetch func() (SystemInfo, error)
+	// cpuTimes reads the CPU counters; lastCPU is the reading of the
+	// previous sample, where the CPUPercent window starts
+	cpuTimes func() (cpu.TimesStat, error)
+	lastCPU  cpu.TimesStat
+
+	mu      sync.RWMutex
+	samples []Sample
+	next    int
+	count   int
+	firing  map[string]bool
+}
+
+func NewSampler(opts SamplerOptions) *Sampler {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.History <= 0 {
+		opts.History = 60
+	}
+	return &Sampler{
+		opts:     opts,
+		fetch:    NewSystemInfo,
+		cpuTimes: readCPUTimes,
+		samples:  make([]Sample, opts.History),
+		firing:   make(map[string]bool),
+	}
+}
+
+// Run samples immediately and then every Interval until ctx is done.
+func (s *Sampler) Run(ctx context.Context) {
+	s.sample()
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// SetThresholds swaps the alert thresholds, e.g. after a config reload.
+// Alerts already firing stay firing until they resolve under the new limits.
+func (s *Sampler) SetThresholds(t Thresholds) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.Thresholds = t
+}
+
+// SetNotifier swaps where alerts go; nil only logs them.
+func (s *Sampler) SetNotifier(n Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.Notifier = n
+}
+
+// Latest returns the most recent sample. Its signature matches
+// NewSystemInfo so it can be used as a drop-in source.
+func (s *Sampler) Latest() (SystemInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.count == 0 {
+		return SystemInfo{}, ErrNoSample
+	}
+	i := (s.next - 1 + len(s.samples)) % len(s.samples)
+	return s.samples[i].Info, nil
+}
+
+// History returns the buffered samples, oldest first.
+func (s *Sampler) History() []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Sample, 0, s.count)
+	start := (s.next - s.count + len(s.samples)) % len(s.samples)
+	for i := range s.count {
+		out = append(out, s.samples[(start+i)%len(s.samples)])
+	}
+	return out
+}
+
+// Refresh reads the system right away instead of waiting for the next tick.
+// It is read only: the reading isn't added to History, alerts aren't
+// evaluated and the resolver isn't asked, so ?refresh=true can't be used to
+// hammer the resolver or Discord. CPU usage needs a window, so it is the
+// one from the last sample.
+func (s *Sampler) Refresh() (SystemInfo, error) {
+	si, err := s.fetch()
+	if err != nil {
+		log.Println("[hwinfo] refresh failed:", err)
+		return SystemInfo{}, err
+	}
+	if latest, err := s.Latest(); err == nil {
+		si.CPUPercent = latest.CPUPercent
+	}
+	return si, nil
+}
+
+func (s *Sampler) sample() error {
+	si, err := s.fetch()
+	if err != nil {
+		log.Println("[hwinfo] sample failed:", err)
+		return err
+	}
+
+	si.CPUPercent = s.cpuPercent()
+	cur := Sample{At: time.Now(), Info: si}
+	if s.opts.Resolver != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		ip, err := s.opts.Resolver.PublicIP(ctx)
+		cancel()
+		if err != nil {
+			log.Println("[hwinfo] public ip lookup failed:", err)
+		}
+		cur.PublicIP = ip
+	}
+
+	s.mu.Lock()
+	var prev *Sample
+	if s.count > 0 {
+		p := s.samples[(s.next-1+len(s.samples))%len(s.samples)]
+		prev = &p
+		// lookup ล้มเหลวก็ใช้ค่าเดิมไปก่อน จะได้ไม่แจ้งเตือนว่า IP เปลี่ยนหลอกๆ
+		if cur.PublicIP == "" {
+			cur.PublicIP = prev.PublicIP
+		}
+	}
+	s.samples[s.next] = cur
+	s.next = (s.next + 1) % len(s.samples)
+	if s.count < len(s.samples) {
+		s.count++
+	}
+	alerts := s.evaluate(prev, cur)
+	s.mu.Unlock()
+
+	for _, a := range alerts {
+		s.notify(a)
+	}
+	return nil
+}
+
+// cpuPercent is the CPU usage since the previous sample, or since boot on
+// the first. Only sample calls it, so nothing else can shorten the window.
+func (s *Sampler) cpuPercent() float64 {
+	cur, err := s.cpuTimes()
+	if err != nil {
+		log.Println("[hwinfo] can't read cpu times:", err)
+		return 0
+	}
+	prev := s.lastCPU
+	s.lastCPU = cur
+
+	busy := func(t cpu.TimesStat) float64 { return t.Total() - t.Idle }
+	total := cur.Total() - prev.Total()
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(100, math.Max(0, (busy(cur)-busy(prev))/total*100))
+}
+
+func readCPUTimes() (cpu.TimesStat, error) {
+	times, err := cpu.Times(false)
+	if err != nil {
+		return cpu.TimesStat{}, err
+	}
+	if len(times) == 0 {
+		return cpu.TimesStat{}, errors.New("no cpu times")
+	}
+	return times[0], nil
+}
+
+// evaluate must be called with s.mu held.
+func (s *Sampler) evaluate(prev *Sample, cur Sample) []discordbot.Embed {
+	var alerts []discordbot.Embed
+	t := s.opts.Thresholds
+	si := cur.Info
+
+	rebooted := false
+	if prev == nil {
+		bootedAt := time.Unix(int64(si.Host.BootTime), 0)
+		rebooted = t.RecentBoot > 0 && si.Host.BootTime > 0 && time.Since(bootedAt) < t.RecentBoot
+	} else {
+		rebooted = prev.Info.Host.BootTime > 0 && si.Host.BootTime > prev.Info.Host.BootTime
+	}
+	if rebooted {
+		alerts = append(alerts, discordbot.Embed{
+			Title:       "Reboot detected",
+			Description: fmt.Sprintf("%s booted at %s", si.Host.Hostname, time.Unix(int64(si.Host.BootTime), 0).Format(time.DateTime)),
+		})
+	}
+
+	if prev != nil {
+		if changes := DiffNetwork(prev.Info.Network, si.Network, s.opts.IgnoreInterfaces); len(changes) > 0 {
+			var desc strings.Builder
+			for _, c := range changes {
+				fmt.Fprintf(&desc, "**%s**\nold: %s\nnew: %s\n", c.Interface, describeAddresses(c.Old), describeAddresses(c.New))
+			}
+			alerts = append(alerts, discordbot.Embed{
+				Title:       "Network address changed on " + si.Host.Hostname,
+				Description: desc.String(),
+			})
+		}
+		if prev.PublicIP != "" && cur.PublicIP != prev.PublicIP {
+			alerts = append(alerts, discordbot.Embed{
+				Title:       "Public IP changed on " + si.Host.Hostname,
+				Description: fmt.Sprintf("old: %s\nnew: %s", prev.PublicIP, cur.PublicIP),
+			})
+		}
+	}
+
+	if t.MemoryPercent > 0 {
+		if a, ok := s.check("memory", "Memory usage", "%", si.Memory.UsedPercent, t.MemoryPercent, t.Hysteresis); ok {
+			alerts = append(alerts, a)
+		}
+	}
+
+	if t.TemperatureCelsius > 0 {
+		for _, temp := range si.Temperatures {
+			if a, ok := s.check("temperature:"+temp.Sensor, "Temperature "+temp.Sensor, "°C", temp.Celsius, t.TemperatureCelsius, t.Hysteresis); ok {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+
+	if t.DiskPercent > 0 {
+		for _, d := range si.Disks {
+			if d.TotalGB == 0 {
+				continue
+			}
+			if a, ok := s.check("disk:"+d.MountPoint, "Disk "+d.MountPoint+" usage", "%", d.UsedPercent, t.DiskPercent, t.Hysteresis); ok {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+
+	return alerts
+}
+
+// check reports a firing or resolved embed when key crosses limit.
+func (s *Sampler) check(key, label, unit string, value, limit, hysteresis float64) (discordbot.Embed, bool) {
+	switch {
+	case !s.firing[key] && value >= limit:
+		s.firing[key] = true
+		return discordbot.Embed{
+			Title:       label + " high",
+			Description: fmt.Sprintf("%s is %.2f%s (threshold %.0f%s)", label, value, unit, limit, unit),
+		}, true
+	case s.firing[key] && value < limit-hysteresis:
+		s.firing[key] = false
+		return discordbot.Embed{
+			Title:       label + " back to normal",
+			Description: fmt.Sprintf("%s is %.2f%s", label, value, unit),
+		}, true
+	}
+	return discordbot.Embed{}, false
+}
+
+func (s *Sampler) notify(e discordbot.Embed) {
+	log.Printf("[hwinfo] %s: %s", e.Title, e.Description)
+	s.mu.RLock()
+	notifier := s.opts.Notifier
+	s.mu.RUnlock()
+	if notifier == nil {
+		return
+	}
+	if err := notifier.SendMessage(discordbot.ThePayload{Embeds: []discordbot.Embed{e}}); err != nil {
+		log.Println("[hwinfo] failed to send alert:", err)
+	}
+}
@@ -0,0 +1,129 @@
+package hwinfo
+
+import (
+	"Panong/pkg/discordbot"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/shirou/gopsutil/cpu"
+)
+
+func TestCheck(t *testing.T) {
+	// limit 90, hysteresis 5: fires at 90 and clears below 85
+	steps := []struct {
+		value float64
+		want  string
+	}{
+		{80, ""},
+		{89.99, ""},
+		{90, "Disk / usage high"},
+		{97, ""},
+		{89, ""},
+		{85, ""},
+		{84.99, "Disk / usage back to normal"},
+		{88, ""},
+		{84, ""},
+		{91, "Disk / usage high"},
+	}
+	s := NewSampler(SamplerOptions{})
+	for i, step := range steps {
+		e, ok := s.check("disk:/", "Disk / usage", "%", step.value, 90, 5)
+		if got := e.Title; ok != (step.want != "") || got != step.want {
+			t.Errorf("step %d (%g) = %q %v, want %q", i, step.value, got, ok, step.want)
+		}
+	}
+}
+
+func TestCheckKeysAreIndependent(t *testing.T) {
+	s := NewSampler(SamplerOptions{})
+	if _, ok := s.check("disk:/", "Disk /", "%", 95, 90, 5); !ok {
+		t.Fatal("disk:/ didn't fire")
+	}
+	if _, ok := s.check("disk:/data", "Disk /data", "%", 95, 90, 5); !ok {
+		t.Error("disk:/data didn't fire while disk:/ was firing")
+	}
+	if _, ok := s.check("disk:/", "Disk /", "%", 95, 90, 5); ok {
+		t.Error("disk:/ fired twice")
+	}
+}
+
+type notifier struct {
+	mu   sync.Mutex
+	sent []discordbot.Embed
+}
+
+func (n *notifier) SendMessage(tp discordbot.ThePayload) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, tp.Embeds...)
+	return nil
+}
+
+func TestRefreshIsReadOnly(t *testing.T) {
+	n := &notifier{}
+	s := NewSampler(SamplerOptions{Notifier: n, Thresholds: Thresholds{MemoryPercent: 90, Hysteresis: 5}})
+	used := 50.0
+	s.fetch = func() (SystemInfo, error) {
+		return SystemInfo{Memory: MemoryInfo{UsedPercent: used}}, nil
+	}
+
+	if _, err := s.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Latest(); !errors.Is(err, ErrNoSample) {
+		t.Errorf("Latest after Refresh = %v, want ErrNoSample", err)
+	}
+
+	s.sample()
+	used = 99
+	si, err := s.Refresh()
+	if err != nil || si.Memory.UsedPercent != 99 {
+		t.Fatalf("Refresh = %v %v, want memory at 99%%", si.Memory.UsedPercent, err)
+	}
+	if history := s.History(); len(history) != 1 || history[0].Info.Memory.UsedPercent != 50 {
+		t.Errorf("history = %+v, want only the scheduled sample", history)
+	}
+	if len(n.sent) != 0 {
+		t.Errorf("Refresh sent alerts %+v", n.sent)
+	}
+
+	s.sample()
+	if len(n.sent) != 1 || n.sent[0].Title != "Memory usage high" {
+		t.Errorf("scheduled sample sent %+v, want one memory alert", n.sent)
+	}
+}
+
+func TestCPUWindowBelongsToSampler(t *testing.T) {
+	s := NewSampler(SamplerOptions{})
+	s.fetch = func() (SystemInfo, error) { return SystemInfo{}, nil }
+	reads := 0
+	readings := []cpu.TimesStat{
+		{User: 30, Idle: 70},
+		// ช่วงถัดไป busy 50 จาก 100
+		{User: 70, System: 10, Idle: 120},
+	}
+	s.cpuTimes = func() (cpu.TimesStat, error) {
+		reads++
+		return readings[reads-1], nil
+	}
+
+	s.sample()
+	if si, _ := s.Latest(); si.CPUPercent != 30 {
+		t.Errorf("first sample cpu = %g, want 30 (since boot)", si.CPUPercent)
+	}
+	for range 3 {
+		si, err := s.Refresh()
+		if err != nil || si.CPUPercent != 30 {
+			t.Errorf("Refresh cpu = %g %v, want the sampled 30", si.CPUPercent, err)
+		}
+	}
+	if reads != 1 {
+		t.Fatalf("read cpu times %d times, want only by the sample", reads)
+	}
+
+	s.sample()
+	if si, _ := s.Latest(); si.CPUPercent != 50 {
+		t.Errorf("second sample cpu = %g, want 50 over the interval", si.CPUPercent)
+	}
+}
@@ -0,0 +1,62 @@
+package hwinfo
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+const thermalZones = "/sys/class/thermal"
+
+// readTemperatures asks gopsutil (hwmon) first and falls back to the kernel
+// thermal zones, which is all a lot of ARM boards expose.
+func readTemperatures() []TemperatureInfo {
+	var temps []TemperatureInfo
+	if sensors, err := host.SensorsTemperatures(); err == nil || len(sensors) > 0 {
+		for _, sensor := range sensors {
+			if sensor.Temperature <= 0 {
+				continue
+			}
+			temps = append(temps, TemperatureInfo{
+				Sensor:  sensor.SensorKey,
+				Celsius: sensor.Temperature,
+			})
+		}
+	}
+	if len(temps) > 0 {
+		return temps
+	}
+	return readThermalZones(thermalZones)
+}
+
+func readThermalZones(root string) []TemperatureInfo {
+	zones, err := filepath.Glob(filepath.Join(root, "thermal_zone*"))
+	if err != nil {
+		return nil
+	}
+
+	var temps []TemperatureInfo
+	for _, zone := range zones {
+		raw, err := os.ReadFile(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+		if err != nil {
+			continue
+		}
+
+		name := filepath.Base(zone)
+		if kind, err := os.ReadFile(filepath.Join(zone, "type")); err == nil {
+			name = strings.TrimSpace(string(kind))
+		}
+		temps = append(temps, TemperatureInfo{
+			Sensor:  name,
+			Celsius: milli / 1000,
+		})
+	}
+	return temps
+}
@@ -0,0 +1,237 @@
+// Package idempotency replays the first answer to a request retried with the
+// same Idempotency-Key, so a mobile app retrying a PUT on a flaky connection
+// doesn't switch a light twice.
+package idempotency
+
+import (
+	"Panong/pkg/auth"
+	"Panong/pkg/response"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const Header = "Idempotency-Key"
+
+const maxKeyLength = 255
+
+// Keys remembers answers by client and key for TTL, in memory and, with a DB,
+// in idempotency_keys so they survive a restart.
+type Keys struct {
+	DB  *pgxpool.Pool
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[entryKey]*entry
+}
+
+type entryKey struct {
+	// client is auth.ClientKey
+	client string
+	key    string
+}
+
+type entry struct {
+	// fingerprint is the method, URL and body the key was first used with
+	fingerprint string
+	status      int
+	header      http.Header
+	body        []byte
+	created     time.Time
+	// done is closed once the first request has been answered
+	done chan struct{}
+}
+
+// stored says whether an answer is replayed. 429 and 503 mean nothing was
+// done, so on purpose a retry with the same key runs again.
+func stored(status int) bool {
+	return status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable
+}
+
+// Middleware handles POST, PUT, PATCH and DELETE requests that carry the
+// header; it must run after authentication.
+func (k *Keys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			key = ""
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			response.BadRequest(w, r, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			response.BadRequest(w, r, "can't read request body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id := entryKey{auth.ClientKey(r), key}
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		e, first := k.begin(r.Context(), id, fingerprint)
+		if !first {
+			if e.fingerprint != fingerprint {
+				response.Fail(w, r, response.NewError(http.StatusConflict, response.CodeConflict,
+					"Idempotency-Key was already used for a different request"))
+				return
+			}
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			e.replay(w)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		var buf bytes.Buffer
+		ww.Tee(&buf)
+		served := false
+		defer func() {
+			if !served {
+				k.abandon(id, e)
+			}
+		}()
+		next.ServeHTTP(ww, r)
+		served = true
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// never nil, body is NOT NULL
+		k.finish(r.Context(), id, e, status, w.Header().Clone(), append([]byte{}, buf.Bytes()...))
+	})
+}
+
+// begin returns the entry for id, or starts one and reports first when
+// this request is the first to use the key
+func (k *Keys) begin(ctx context.Context, id entryKey, fingerprint string) (*entry, bool) {
+	k.mu.Lock()
+	k.prune(time.Now())
+	e, ok := k.entries[id]
+	k.mu.Unlock()
+	if ok {
+		return e, false
+	}
+
+	saved, err := k.load(ctx, id)
+	if err != nil {
+		log.Println("[idempotency] failed to load key:", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// อาจมีคำขอเดียวกันเข้ามาระหว่างอ่าน DB
+	if e, ok := k.entries[id]; ok {
+		return e, false
+	}
+	if saved != nil {
+		k.entries[id] = saved
+		return saved, false
+	}
+	e = &entry{fingerprint: fingerprint, created: time.Now(), done: make(chan struct{})}
+	k.entries[id] = e
+	return e, true
+}
+
+func (k *Keys) finish(ctx context.Context, id entryKey, e *entry, status int, header http.Header, body []byte) {
+	k.mu.Lock()
+	e.status, e.header, e.body = status, header, body
+	if !stored(status) && k.entries[id] == e {
+		delete(k.entries, id)
+	}
+	close(e.done)
+	k.mu.Unlock()
+
+	if !stored(status) {
+		return
+	}
+	// client อาจตัดไปแล้ว แต่คำตอบต้องเก็บไว้ให้ retry
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := k.save(saveCtx, id, e); err != nil {
+		log.Println("[idempotency] failed to save key:", err)
+	}
+}
+
+// abandon forgets an entry whose handler panicked, so a retry runs again and
+// requests waiting on it get a 500 instead of hanging
+func (k *Keys) abandon(id entryKey, e *entry) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e.status, e.body = http.StatusInternalServerError, []byte{}
+	if k.entries[id] == e {
+		delete(k.entries, id)
+	}
+	close(e.done)
+}
+
+// prune must be called with k.mu held
+func (k *Keys) prune(now time.Time) {
+	if k.entries == nil {
+		k.entries = make(map[entryKey]*entry)
+	}
+	for id, e := range k.entries {
+		if now.Sub(e.created) >= k.TTL && isDone(e) {
+			delete(k.entries, id)
+		}
+	}
+}
+
+func isDone(e *entry) bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *entry) replay(w http.ResponseWriter) {
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// Run deletes expired keys from the database every hour until ctx is done.
+func (k *Keys) Run(ctx context.Context) {
+	if k.DB == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := k.purge(ctx); err != nil && ctx.Err() == nil {
+			log.Println("[idempotency] failed to purge expired keys:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
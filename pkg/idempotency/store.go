@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// load reads a saved answer; nil without a DB or when there is none
+func (k *Keys) load(ctx context.Context, id entryKey) (*entry, error) {
+	if k.DB == nil {
+		return nil, nil
+	}
+	query := `
+    SELECT fingerprint, status, header, body, created_at
+    FROM idempotency_keys
+    WHERE client = $1 AND key = $2 AND created_at > $3;
+    `
+	var (
+		e      = entry{done: make(chan struct{})}
+		header []byte
+	)
+	err := k.DB.QueryRow(ctx, query, id.client, id.key, time.Now().Add(-k.TTL)).
+		Scan(&e.fingerprint, &e.status, &header, &e.body, &e.created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(header, &e.header); err != nil {
+		return nil, err
+	}
+	close(e.done)
+	return &e, nil
+}
+
+func (k *Keys) save(ctx context.Context, id entryKey, e *entry) error {
+	if k.DB == nil {
+		return nil
+	}
+	header, err := json.Marshal(e.header)
+	if err != nil {
+		return err
+	}
+	// key ที่หมดอายุแล้วใช้ซ้ำได้
+	query := `
+    INSERT INTO idempotency_keys (client, key, fingerprint, status, header, body, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    ON CONFLICT (client, key) DO UPDATE
+    SET fingerprint = EXCLUDED.fingerprint,
+        status = EXCLUDED.status,
+        header = EXCLUDED.header,
+        body = EXCLUDED.body,
+        created_at = EXCLUDED.created_at
+    WHERE idempotency_keys.created_at <= $8;
+    `
+	_, err = k.DB.Exec(ctx, query, id.client, id.key, e.fingerprint, e.status, header, e.body, e.created, time.Now().Add(-k.TTL))
+	return err
+}
+
+func (k *Keys) purge(ctx context.Context) error {
+	_, err := k.DB.Exec(ctx, `DELETE FROM idempotency_keys WHERE created_at <= $1;`, time.Now().Add(-k.TTL))
+	return err
+}
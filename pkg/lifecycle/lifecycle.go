@@ -0,0 +1,136 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// App owns the HTTP server and the background workers. On SIGINT/SIGTERM it
+// tears them down in order: drain HTTP, stop workers, then run the stop
+// hooks (outbox flushes, MQTT, database) in the order they were added.
+type App struct {
+	Server *http.Server
+	// ShutdownTimeout is how long HTTP gets to drain
+	ShutdownTimeout time.Duration
+	// StopTimeout is how long the workers get to exit after the drain, and
+	// then each stop hook on its own, so a slow drain or hook doesn't leave
+	// the hooks after it with an expired context
+	StopTimeout time.Duration
+
+	workers     context.Context
+	stopWorkers context.CancelFunc
+	wg          sync.WaitGroup
+
+	mu    sync.Mutex
+	hooks []hook
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func New(server *http.Server, shutdownTimeout time.Duration) *App {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 15 * time.Second
+	}
+	workers, stop := context.WithCancel(context.Background())
+	return &App{
+		Server:          server,
+		ShutdownTimeout: shutdownTimeout,
+		StopTimeout:     5 * time.Second,
+		workers:         workers,
+		stopWorkers:     stop,
+	}
+}
+
+// Go runs fn in the background. Its context is cancelled once HTTP has been
+// drained and shutdown waits for fn to return. fn may also return early,
+// e.g. once a connection is up.
+func (a *App) Go(fn func(ctx context.Context)) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		fn(a.workers)
+	}()
+}
+
+// OnStop registers fn to run during shutdown after the workers have exited.
+func (a *App) OnStop(name string, fn func(ctx context.Context) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hooks = append(a.hooks, hook{name: name, fn: fn})
+}
+
+// Run serves HTTP until a signal arrives or the listener fails, then shuts
+// everything down. A second signal during shutdown kills the process.
+func (a *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- a.Server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Println("[lifecycle] signal received, shutting down")
+	case err = <-serveErr:
+		log.Println("[lifecycle] HTTP server failed, shutting down:", err)
+	}
+	stop()
+
+	return errors.Join(err, a.Shutdown())
+}
+
+func (a *App) Shutdown() error {
+	var errs []error
+	ctx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	if err := a.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http: %w", err))
+	}
+	cancel()
+
+	a.stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(a.StopTimeout):
+		errs = append(errs, fmt.Errorf("workers: still running after %s", a.StopTimeout))
+	}
+
+	a.mu.Lock()
+	hooks := a.hooks
+	a.mu.Unlock()
+	for _, h := range hooks {
+		if err := a.stop(h); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	log.Println("[lifecycle] shutdown complete")
+	return nil
+}
+
+func (a *App) stop(h hook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.StopTimeout)
+	defer cancel()
+	return h.fn(ctx)
+}
@@ -0,0 +1,59 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEveryStopHookGetsItsOwnTimeout(t *testing.T) {
+	a := New(&http.Server{}, time.Second)
+	a.StopTimeout = 50 * time.Millisecond
+
+	var ran []string
+	a.OnStop("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		ran = append(ran, "slow")
+		return ctx.Err()
+	})
+	a.OnStop("mqtt", func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ran = append(ran, "mqtt")
+		return nil
+	})
+
+	err := a.Shutdown()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.HasPrefix(err.Error(), "slow: ") {
+		t.Errorf("Shutdown = %v, want only slow to time out", err)
+	}
+	if len(ran) != 2 || ran[1] != "mqtt" {
+		t.Errorf("ran %v, want mqtt after slow", ran)
+	}
+}
+
+func TestStuckWorkerDoesNotStarveHooks(t *testing.T) {
+	a := New(&http.Server{}, time.Second)
+	a.StopTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	a.Go(func(ctx context.Context) { <-release })
+
+	var hookErr error
+	a.OnStop("database", func(ctx context.Context) error {
+		hookErr = ctx.Err()
+		return nil
+	})
+
+	err := a.Shutdown()
+	if err == nil || !strings.HasPrefix(err.Error(), "workers: ") {
+		t.Errorf("Shutdown = %v, want the stuck worker reported", err)
+	}
+	if hookErr != nil {
+		t.Errorf("hook got a context that was already done: %v", hookErr)
+	}
+}
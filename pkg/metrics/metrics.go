@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "panong"
+
+// Registry holds every metric exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   []float64{.005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "route"})
+
+	mqttPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "publish_total",
+		Help:      "MQTT messages published by topic.",
+	}, []string{"topic"})
+
+	mqttSubscribed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "subscribe_total",
+		Help:      "MQTT subscriptions made by topic.",
+	}, []string{"topic"})
+
+	mqttConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "connected",
+		Help:      "1 while the MQTT client is connected to a broker.",
+	})
+
+	mqttConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "connections_total",
+		Help:      "Successful MQTT connections by broker URL.",
+	}, []string{"broker"})
+
+	mqttStatusRoundTrip = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "status_roundtrip_seconds",
+		Help:      "Time between a zigbee2mqtt /get request and its reply.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2, 5, 10, 30},
+	}, []string{"device"})
+
+	mqttTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mqtt",
+		Name:      "status_timeouts_total",
+		Help:      "Status requests that got no reply in time.",
+	}, []string{"device"})
+
+	commandsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "command",
+		Name:      "throttled_total",
+		Help:      "Device commands answered without publishing, by reason (rate_limit, coalesced).",
+	}, []string{"reason"})
+
+	deviceState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "device",
+		Name:      "state",
+		Help:      "Last known device state (1 = ON/OPEN, 0 = OFF/CLOSED).",
+	}, []string{"type", "device"})
+
+	deviceLinkQuality = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "device",
+		Name:      "linkquality",
+		Help:      "Last reported zigbee link quality.",
+	}, []string{"type", "device"})
+
+	deviceBattery = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "device",
+		Name:      "battery_percent",
+		Help:      "Last reported battery level.",
+	}, []string{"type", "device"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		mqttPublished,
+		mqttSubscribed,
+		mqttConnected,
+		mqttConnections,
+		mqttStatusRoundTrip,
+		mqttTimeouts,
+		commandsThrottled,
+		deviceState,
+		deviceLinkQuality,
+		deviceBattery,
+	)
+}
+
+// Handler serves Registry in the Prometheus text exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request count and latency labelled with the chi route
+// pattern, so /light/{light} stays one series no matter which light is hit.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func MQTTPublished(topic string) {
+	mqttPublished.WithLabelValues(topic).Inc()
+}
+
+func MQTTSubscribed(topic string) {
+	mqttSubscribed.WithLabelValues(topic).Inc()
+}
+
+func MQTTConnected(broker string) {
+	mqttConnected.Set(1)
+	mqttConnections.WithLabelValues(broker).Inc()
+}
+
+func MQTTDisconnected() {
+	mqttConnected.Set(0)
+}
+
+func StatusRoundTrip(device string, d time.Duration) {
+	mqttStatusRoundTrip.WithLabelValues(device).Observe(d.Seconds())
+}
+
+func StatusTimeout(device string) {
+	mqttTimeouts.WithLabelValues(device).Inc()
+}
+
+func CommandThrottled(reason string) {
+	commandsThrottled.WithLabelValues(reason).Inc()
+}
+
+// ObserveDeviceStatus updates the device gauges from a raw zigbee2mqtt
+// payload. Fields the device didn't report are left untouched.
+func ObserveDeviceStatus(kind, device string, payload []byte) {
+	var status struct {
+		State       *string  `json:"state"`
+		Linkquality *float64 `json:"linkquality"`
+		Battery     *float64 `json:"battery"`
+	}
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return
+	}
+
+	if status.State != nil {
+		switch strings.ToUpper(*status.State) {
+		case "ON", "OPEN":
+			deviceState.WithLabelValues(kind, device).Set(1)
+		case "OFF", "CLOSE", "CLOSED":
+			deviceState.WithLabelValues(kind, device).Set(0)
+		}
+	}
+	if status.Linkquality != nil {
+		deviceLinkQuality.WithLabelValues(kind, device).Set(*status.Linkquality)
+	}
+	if status.Battery != nil {
+		deviceBattery.WithLabelValues(kind, device).Set(*status.Battery)
+	}
+}
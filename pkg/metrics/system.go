@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"Panong/pkg/hwinfo"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// SystemCollector exposes hwinfo.SystemInfo as gauges. The source is called
+// on every scrape.
+type SystemCollector struct {
+	source func() (hwinfo.SystemInfo, error)
+
+	cpuThreads     *prometheus.Desc
+	cpuCores       *prometheus.Desc
+	cpuMhz         *prometheus.Desc
+	cpuPercent     *prometheus.Desc
+	load           *prometheus.Desc
+	temperature    *prometheus.Desc
+	uptime         *prometheus.Desc
+	memTotal       *prometheus.Desc
+	memUsed        *prometheus.Desc
+	memUsedPercent *prometheus.Desc
+	diskTotal      *prometheus.Desc
+	diskUsed       *prometheus.Desc
+	diskPercent    *prometheus.Desc
+}
+
+func NewSystemCollector(source func() (hwinfo.SystemInfo, error)) *SystemCollector {
+	name := func(n string) string {
+		return prometheus.BuildFQName(namespace, "system", n)
+	}
+	return &SystemCollector{
+		source:         source,
+		cpuThreads:     prometheus.NewDesc(name("cpu_threads"), "Logical CPUs reported by the host.", nil, nil),
+		cpuCores:       prometheus.NewDesc(name("cpu_cores"), "Cores of the first CPU.", nil, nil),
+		cpuMhz:         prometheus.NewDesc(name("cpu_mhz"), "Clock speed of the first CPU.", nil, nil),
+		cpuPercent:     prometheus.NewDesc(name("cpu_usage_percent"), "CPU usage since the previous sample.", nil, nil),
+		load:           prometheus.NewDesc(name("load_average"), "Load average by window.", []string{"window"}, nil),
+		temperature:    prometheus.NewDesc(name("temperature_celsius"), "Temperature per sensor.", []string{"sensor"}, nil),
+		uptime:         prometheus.NewDesc(name("uptime_hours"), "Host uptime in hours.", nil, nil),
+		memTotal:       prometheus.NewDesc(name("memory_total_bytes"), "Total memory.", nil, nil),
+		memUsed:        prometheus.NewDesc(name("memory_used_bytes"), "Used memory.", nil, nil),
+		memUsedPercent: prometheus.NewDesc(name("memory_used_percent"), "Used memory in percent.", nil, nil),
+		diskTotal:      prometheus.NewDesc(name("disk_total_bytes"), "Disk size per mount point.", []string{"mount_point"}, nil),
+		diskUsed:       prometheus.NewDesc(name("disk_used_bytes"), "Disk usage per mount point.", []string{"mount_point"}, nil),
+		diskPercent:    prometheus.NewDesc(name("disk_used_percent"), "Disk usage per mount point in percent.", []string{"mount_point"}, nil),
+	}
+}
+
+func (c *SystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpuThreads
+	ch <- c.cpuCores
+	ch <- c.cpuMhz
+	ch <- c.cpuPercent
+	ch <- c.load
+	ch <- c.temperature
+	ch <- c.uptime
+	ch <- c.memTotal
+	ch <- c.memUsed
+	ch <- c.memUsedPercent
+	ch <- c.diskTotal
+	ch <- c.diskUsed
+	ch <- c.diskPercent
+}
+
+func (c *SystemCollector) Collect(ch chan<- prometheus.Metric) {
+	si, err := c.source()
+	if err != nil {
+		log.Println("[metrics] system info:", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.cpuThreads, prometheus.GaugeValue, float64(len(si.CPU)))
+	if len(si.CPU) > 0 {
+		ch <- prometheus.MustNewConstMetric(c.cpuCores, prometheus.GaugeValue, float64(si.CPU[0].Cores))
+		ch <- prometheus.MustNewConstMetric(c.cpuMhz, prometheus.GaugeValue, si.CPU[0].Mhz)
+	}
+	ch <- prometheus.MustNewConstMetric(c.cpuPercent, prometheus.GaugeValue, si.CPUPercent)
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, si.Load.Load1, "1m")
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, si.Load.Load5, "5m")
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, si.Load.Load15, "15m")
+	sensors := make(map[string]bool)
+	for _, t := range si.Temperatures {
+		if sensors[t.Sensor] {
+			continue
+		}
+		sensors[t.Sensor] = true
+		ch <- prometheus.MustNewConstMetric(c.temperature, prometheus.GaugeValue, t.Celsius, t.Sensor)
+	}
+	ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, float64(si.Host.UptimeHours))
+	ch <- prometheus.MustNewConstMetric(c.memTotal, prometheus.GaugeValue, si.Memory.TotalGB*gib)
+	ch <- prometheus.MustNewConstMetric(c.memUsed, prometheus.GaugeValue, si.Memory.UsedGB*gib)
+	ch <- prometheus.MustNewConstMetric(c.memUsedPercent, prometheus.GaugeValue, si.Memory.UsedPercent)
+
+	seen := make(map[string]bool)
+	for _, d := range si.Disks {
+		if seen[d.MountPoint] {
+			continue
+		}
+		seen[d.MountPoint] = true
+		ch <- prometheus.MustNewConstMetric(c.diskTotal, prometheus.GaugeValue, d.TotalGB*gib, d.MountPoint)
+		ch <- prometheus.MustNewConstMetric(c.diskUsed, prometheus.GaugeValue, d.UsedGB*gib, d.MountPoint)
+		ch <- prometheus.MustNewConstMetric(c.diskPercent, prometheus.GaugeValue, d.UsedPercent, d.MountPoint)
+	}
+}
@@ -0,0 +1,86 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Spec is the hand-maintained OpenAPI 3 document. Keep it in step with the
+// router; Verify reports drift at startup.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Handler serves Spec as /openapi.json
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(Spec)
+}
+
+type document struct {
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+var methods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// Verify walks the router and compares every method+path with Spec. Paths in
+// skip (static assets, redirects) are ignored. It returns one line per
+// mismatch, so an empty result means the two agree.
+func Verify(routes chi.Routes, skip ...string) ([]string, error) {
+	var doc document
+	if err := json.Unmarshal(Spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi.json: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if slices.Contains(methods, method) {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routed := make(map[string]bool)
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = normalize(route)
+		if slices.Contains(skip, route) {
+			return nil
+		}
+		routed[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for op := range routed {
+		if !documented[op] {
+			problems = append(problems, "not documented: "+op)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			problems = append(problems, "not routed: "+op)
+		}
+	}
+	slices.Sort(problems)
+	return problems, nil
+}
+
+// normalize turns chi's mounted patterns ("/system/", "/light/*/{light}")
+// into the form used in the spec.
+func normalize(route string) string {
+	route = strings.ReplaceAll(route, "/*/", "/")
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
@@ -2,6 +2,11 @@ package response
 
 import (
 	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/render"
 )
 
 type HTTPResponse struct {
@@ -11,16 +16,15 @@ type HTTPResponse struct {
 }
 
 type marshalResponse struct {
-	Data  any     `json:"data"`
-	Error *string `json:"error"`
+	Data  any    `json:"data"`
+	Error *Error `json:"error"`
 }
 
 func newMarshalResponse(hr *HTTPResponse) *marshalResponse {
 	if hr.Error != nil {
-		errMsg := hr.Error.Error()
 		return &marshalResponse{
 			Data:  hr.Data,
-			Error: &errMsg,
+			Error: From(hr.Error),
 		}
 	}
 	return &marshalResponse{
@@ -33,3 +37,130 @@ func (h HTTPResponse) MarshalJSON() ([]byte, error) {
 	r := newMarshalResponse(&h)
 	return json.Marshal(r)
 }
+
+// Code is the machine-readable part of an error, stable across releases
+type Code string
+
+const (
+	CodeBadRequest         Code = "BAD_REQUEST"
+	CodeUnauthorized       Code = "UNAUTHORIZED"
+	CodeForbidden          Code = "FORBIDDEN"
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeMethodNotAllowed   Code = "METHOD_NOT_ALLOWED"
+	CodeConflict           Code = "CONFLICT"
+	CodeTooManyRequests    Code = "TOO_MANY_REQUESTS"
+	CodeDeviceNotFound     Code = "DEVICE_NOT_FOUND"
+	CodeInvalidState       Code = "INVALID_STATE"
+	CodeDeviceTimeout      Code = "DEVICE_TIMEOUT"
+	CodeInvalidDeviceData  Code = "INVALID_DEVICE_DATA"
+	CodeMQTTDisconnected   Code = "MQTT_DISCONNECTED"
+	CodeMQTTError          Code = "MQTT_ERROR"
+	CodeServiceUnavailable Code = "SERVICE_UNAVAILABLE"
+	CodeInternal           Code = "INTERNAL_ERROR"
+)
+
+// Error is what clients get in the "error" field of every response.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func NewError(status int, code Code, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithDetails returns a copy carrying extra context, e.g. per-device errors.
+func (e *Error) WithDetails(details any) *Error {
+	out := *e
+	out.Details = details
+	return &out
+}
+
+type mapping struct {
+	target error
+	status int
+	code   Code
+}
+
+var (
+	mappingsMu sync.RWMutex
+	mappings   []mapping
+)
+
+// RegisterError maps a domain sentinel error to an HTTP status and code, so
+// packages like command keep returning plain errors and handlers just call
+// Fail. Register from an init func.
+func RegisterError(target error, status int, code Code) {
+	mappingsMu.Lock()
+	defer mappingsMu.Unlock()
+	mappings = append(mappings, mapping{target: target, status: status, code: code})
+}
+
+// From converts any error to an *Error. The message is always the full
+// err.Error() so wrapped context ("light2: timeout ...") isn't lost.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		out := *e
+		out.Message = err.Error()
+		return &out
+	}
+
+	mappingsMu.RLock()
+	defer mappingsMu.RUnlock()
+	for _, m := range mappings {
+		if errors.Is(err, m.target) {
+			return NewError(m.status, m.code, err.Error())
+		}
+	}
+	return NewError(http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// OK writes data in the envelope with 200
+func OK(w http.ResponseWriter, r *http.Request, data any) {
+	JSON(w, r, http.StatusOK, data)
+}
+
+func JSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	render.Status(r, status)
+	render.JSON(w, r, HTTPResponse{
+		Data:  data,
+		Error: nil,
+	})
+}
+
+// Fail writes err in the envelope with the status it maps to
+func Fail(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+	render.Status(r, e.Status)
+	render.JSON(w, r, HTTPResponse{
+		Data:  nil,
+		Error: e,
+	})
+}
+
+func BadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	Fail(w, r, NewError(http.StatusBadRequest, CodeBadRequest, message))
+}
+
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	Fail(w, r, NewError(http.StatusUnauthorized, CodeUnauthorized, "Unauthorized"))
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Fail(w, r, NewError(http.StatusNotFound, CodeNotFound, "route not found"))
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Fail(w, r, NewError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed"))
+}
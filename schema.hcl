@@ -171,3 +171,247 @@ table "users" {
   }
 
 }
+
+table "automation_rules" {
+  schema = schema.public
+  column "id" {
+    null = false
+    type = bigserial
+  }
+  column "name" {
+    null = false
+    type = varchar
+  }
+  column "enabled" {
+    null    = false
+    type    = bool
+    default = true
+  }
+  column "definition" {
+    null = false
+    type = jsonb
+  }
+
+  column "created_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  column "updated_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+}
+
+table "automation_rule_runs" {
+  schema = schema.public
+  column "id" {
+    null = false
+    type = bigserial
+  }
+  column "rule_id" {
+    null = false
+    type = bigint
+  }
+  column "trigger" {
+    null = false
+    type = text
+  }
+  column "status" {
+    null = false
+    type = varchar
+  }
+  column "steps" {
+    null = false
+    type = jsonb
+  }
+  column "error" {
+    null = true
+    type = text
+  }
+  column "started_at" {
+    null = false
+    type = timestamp(3)
+  }
+  column "finished_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  index "ix_automation_rule_runs_rule_id" {
+    columns = [column.rule_id]
+  }
+
+  foreign_key "rule_id_fk" {
+    columns = [column.rule_id]
+    ref_columns = [table.automation_rules.column.id]
+    on_delete = CASCADE
+    on_update = NO_ACTION
+  }
+}
+
+table "command_overrides" {
+  schema = schema.public
+  column "id" {
+    null = false
+    type = bigserial
+  }
+  column "user_id" {
+    null = false
+    type = bigint
+  }
+  column "user_name" {
+    null = false
+    type = varchar
+  }
+  column "device" {
+    null = false
+    type = varchar
+  }
+  column "state" {
+    null = false
+    type = varchar
+  }
+  column "limit" {
+    null = false
+    type = varchar
+  }
+  column "detail" {
+    null = false
+    type = text
+  }
+  column "created_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  index "ix_command_overrides_device" {
+    columns = [column.device]
+  }
+}
+
+table "lamp_hours" {
+  schema = schema.public
+  column "device" {
+    null = false
+    type = varchar
+  }
+  column "burn_seconds" {
+    null    = false
+    type    = bigint
+    default = 0
+  }
+  column "reminded" {
+    null    = false
+    type    = smallint
+    default = 0
+  }
+  column "installed_at" {
+    null = true
+    type = timestamp(3)
+  }
+  column "updated_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.device]
+  }
+}
+
+table "lamp_replacements" {
+  schema = schema.public
+  column "id" {
+    null = false
+    type = bigserial
+  }
+  column "device" {
+    null = false
+    type = varchar
+  }
+  column "burn_seconds" {
+    null = false
+    type = bigint
+  }
+  column "user_id" {
+    null = false
+    type = bigint
+  }
+  column "user_name" {
+    null = false
+    type = varchar
+  }
+  column "note" {
+    null = true
+    type = text
+  }
+  column "created_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  index "ix_lamp_replacements_device" {
+    columns = [column.device]
+  }
+}
+
+table "idempotency_keys" {
+  schema = schema.public
+  column "client" {
+    null    = false
+    type    = varchar(255)
+    comment = "user:<id> or, for the shared secret, secret:<address>"
+  }
+  column "key" {
+    null = false
+    type = varchar(255)
+  }
+  column "fingerprint" {
+    null = false
+    type = varchar
+  }
+  column "status" {
+    null = false
+    type = integer
+  }
+  column "header" {
+    null = false
+    type = jsonb
+  }
+  column "body" {
+    null = false
+    type = bytea
+  }
+  column "created_at" {
+    null    = false
+    type    = timestamp(3)
+    default = sql("CURRENT_TIMESTAMP")
+  }
+
+  primary_key {
+    columns = [column.client, column.key]
+  }
+  index "ix_idempotency_keys_created_at" {
+    columns = [column.created_at]
+  }
+}
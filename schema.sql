@@ -2,12 +2,34 @@
 CREATE SCHEMA IF NOT EXISTS "public";
 -- Set comment to schema: "public"
 COMMENT ON SCHEMA "public" IS 'standard public schema';
+-- Create "automation_rules" table
+CREATE TABLE "public"."automation_rules" ("id" bigserial NOT NULL, "name" character varying NOT NULL, "enabled" boolean NOT NULL DEFAULT true, "definition" jsonb NOT NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, "updated_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"));
+-- Create "automation_rule_runs" table
+CREATE TABLE "public"."automation_rule_runs" ("id" bigserial NOT NULL, "rule_id" bigint NOT NULL, "trigger" text NOT NULL, "status" character varying NOT NULL, "steps" jsonb NOT NULL, "error" text NULL, "started_at" timestamp(3) NOT NULL, "finished_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"), CONSTRAINT "rule_id_fk" FOREIGN KEY ("rule_id") REFERENCES "public"."automation_rules" ("id") ON UPDATE NO ACTION ON DELETE CASCADE);
+-- Create index "ix_automation_rule_runs_rule_id" to table: "automation_rule_runs"
+CREATE INDEX "ix_automation_rule_runs_rule_id" ON "public"."automation_rule_runs" ("rule_id");
+-- Create "command_overrides" table
+CREATE TABLE "public"."command_overrides" ("id" bigserial NOT NULL, "user_id" bigint NOT NULL, "user_name" character varying NOT NULL, "device" character varying NOT NULL, "state" character varying NOT NULL, "limit" character varying NOT NULL, "detail" text NOT NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"));
+-- Create index "ix_command_overrides_device" to table: "command_overrides"
+CREATE INDEX "ix_command_overrides_device" ON "public"."command_overrides" ("device");
 -- Create "discord_toggle_histories" table
 CREATE TABLE "public"."discord_toggle_histories" ("id" bigserial NOT NULL, "action_by" bigserial NOT NULL, "payload" json NOT NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"));
 -- Create index "ix_discord_toggle_histories_action_by" to table: "discord_toggle_histories"
 CREATE INDEX "ix_discord_toggle_histories_action_by" ON "public"."discord_toggle_histories" ("action_by");
 -- Create "function_histories" table
 CREATE TABLE "public"."function_histories" ("id" bigserial NOT NULL, "associate_with" character varying NOT NULL, "called_by_function" character varying NOT NULL, "line" bigint NOT NULL, "file_location" text NOT NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"));
+-- Create "idempotency_keys" table
+CREATE TABLE "public"."idempotency_keys" ("client" character varying(255) NOT NULL, "key" character varying(255) NOT NULL, "fingerprint" character varying NOT NULL, "status" integer NOT NULL, "header" jsonb NOT NULL, "body" bytea NOT NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("client", "key"));
+-- Set comment to column: "client" on table: "idempotency_keys"
+COMMENT ON COLUMN "public"."idempotency_keys"."client" IS 'user:<id> or, for the shared secret, secret:<address>';
+-- Create index "ix_idempotency_keys_created_at" to table: "idempotency_keys"
+CREATE INDEX "ix_idempotency_keys_created_at" ON "public"."idempotency_keys" ("created_at");
+-- Create "lamp_hours" table
+CREATE TABLE "public"."lamp_hours" ("device" character varying NOT NULL, "burn_seconds" bigint NOT NULL DEFAULT 0, "reminded" smallint NOT NULL DEFAULT 0, "installed_at" timestamp(3) NULL, "updated_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("device"));
+-- Create "lamp_replacements" table
+CREATE TABLE "public"."lamp_replacements" ("id" bigserial NOT NULL, "device" character varying NOT NULL, "burn_seconds" bigint NOT NULL, "user_id" bigint NOT NULL, "user_name" character varying NOT NULL, "note" text NULL, "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY ("id"));
+-- Create index "ix_lamp_replacements_device" to table: "lamp_replacements"
+CREATE INDEX "ix_lamp_replacements_device" ON "public"."lamp_replacements" ("device");
 -- Create "user_otps" table
 CREATE TABLE "public"."user_otps" ("id" bigserial NOT NULL, "user_id" bigserial NOT NULL, "otp" character varying NOT NULL, "expired_at" timestamp(3) NOT NULL DEFAULT (CURRENT_TIMESTAMP + '01:00:00'::interval), "created_at" timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP);
 -- Create "users" table
@@ -0,0 +1,279 @@
+'use strict';
+
+const TOKEN_KEY = 'panong.token';
+const HISTORY_LIMIT = 50;
+
+const $ = (sel) => document.querySelector(sel);
+const cards = new Map();
+let token = localStorage.getItem(TOKEN_KEY);
+let socket = null;
+let nextRequestID = 1;
+const pending = new Map();
+
+async function api(method, path, body) {
+  const res = await fetch(path, {
+    method,
+    headers: {
+      'X-Auth-Token': token || '',
+      ...(body ? { 'Content-Type': 'application/json' } : {}),
+    },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (res.status === 401) {
+    logout();
+    throw new Error('session expired');
+  }
+  const text = await res.text();
+  let payload = text;
+  try { payload = JSON.parse(text); } catch (_) { /* plain text */ }
+  if (!res.ok) {
+    const err = payload && payload.error;
+    throw new Error((err && (err.message || err)) || text || res.statusText);
+  }
+  // response.HTTPResponse ห่อไว้ใน data
+  if (payload && typeof payload === 'object' && 'data' in payload && 'error' in payload) {
+    return payload.data;
+  }
+  return payload;
+}
+
+function showLogin() {
+  $('#login-view').hidden = false;
+  $('#panel-view').hidden = true;
+  $('#logout').hidden = true;
+  $('#who').textContent = '';
+}
+
+function logout() {
+  if (token) {
+    fetch('/auth/logout', { method: 'POST', headers: { 'X-Auth-Token': token } }).catch(() => {});
+  }
+  token = null;
+  localStorage.removeItem(TOKEN_KEY);
+  if (socket) socket.close();
+  showLogin();
+}
+
+$('#login-form').addEventListener('submit', async (e) => {
+  e.preventDefault();
+  const form = new FormData(e.target);
+  $('#login-error').textContent = '';
+  try {
+    const session = await api('POST', '/auth/login', {
+      username: form.get('username'),
+      password: form.get('password'),
+    });
+    token = session.token;
+    localStorage.setItem(TOKEN_KEY, token);
+    e.target.reset();
+    start();
+  } catch (err) {
+    $('#login-error').textContent = err.message;
+  }
+});
+
+$('#logout').addEventListener('click', logout);
+
+function renderCard(device) {
+  const node = $('#card-template').content.firstElementChild.cloneNode(true);
+  node.querySelector('.name').textContent = device.name || device.id;
+  node.querySelector('.id').textContent = device.id;
+  node.querySelectorAll('.actions button').forEach((btn) => {
+    btn.addEventListener('click', () => sendCommand(device, btn.dataset.state, node));
+  });
+  cards.set(device.id, node);
+  $(device.type === 'valve' ? '#valves' : '#lights').appendChild(node);
+}
+
+function applyStatus(id, status) {
+  const node = cards.get(id);
+  if (!node || !status) return;
+  if (status.state) {
+    const state = String(status.state).toUpperCase();
+    const el = node.querySelector('.state');
+    el.textContent = state;
+    el.className = 'state ' + (state === 'ON' ? 'on' : state === 'OFF' ? 'off' : 'unknown');
+  }
+  if (status.linkquality !== undefined) node.querySelector('.linkquality').textContent = status.linkquality;
+  if (status.battery !== undefined) node.querySelector('.battery').textContent = status.battery + '%';
+}
+
+function setMessage(node, text, isError) {
+  const el = node.querySelector('.message');
+  el.textContent = text;
+  el.className = 'message' + (isError ? ' error' : '');
+}
+
+async function sendCommand(device, state, node) {
+  const buttons = node.querySelectorAll('.actions button');
+  buttons.forEach((b) => { b.disabled = true; });
+  setMessage(node, 'sending ' + state + '…');
+  try {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+      await wsCommand(device.id, state);
+    } else {
+      await api('PUT', `/${device.type}/${encodeURIComponent(device.id)}/${state}`);
+    }
+    setMessage(node, state + ' sent');
+  } catch (err) {
+    setMessage(node, err.message, true);
+  } finally {
+    buttons.forEach((b) => { b.disabled = false; });
+  }
+}
+
+function wsCommand(device, state) {
+  return new Promise((resolve, reject) => {
+    const id = String(nextRequestID++);
+    pending.set(id, { resolve, reject });
+    socket.send(JSON.stringify({ id, device, state }));
+    setTimeout(() => {
+      if (pending.delete(id)) reject(new Error('no acknowledgement'));
+    }, 35000);
+  });
+}
+
+function addHistory(event) {
+  const list = $('#history');
+  const li = document.createElement('li');
+  const time = document.createElement('time');
+  time.textContent = new Date(event.time).toLocaleTimeString();
+  li.appendChild(time);
+  const name = (cards.get(event.device) && cards.get(event.device).querySelector('.name').textContent) || event.device;
+  const state = event.payload && event.payload.state !== undefined ? event.payload.state : '';
+  li.appendChild(document.createTextNode(`${event.type} ${name} ${state}`));
+  list.prepend(li);
+  while (list.children.length > HISTORY_LIMIT) list.lastChild.remove();
+}
+
+function handleEvent(event) {
+  addHistory(event);
+  const node = cards.get(event.device);
+  if (!node) return;
+  if (event.type === 'state') applyStatus(event.device, event.payload);
+  if (event.type === 'availability' && event.payload) {
+    node.querySelector('.availability').textContent = event.payload.state;
+  }
+}
+
+function connect() {
+  const proto = location.protocol === 'https:' ? 'wss' : 'ws';
+  // token ไปทาง subprotocol ไม่ใส่ใน URL จะได้ไม่ลง log
+  socket = new WebSocket(`${proto}://${location.host}/ws`, ['panong', `token.${token}`]);
+  socket.onopen = () => {
+    $('#conn').textContent = 'live';
+    $('#conn').className = 'badge online';
+    socket.send(JSON.stringify({ id: 'sub', type: 'subscribe', filter: {} }));
+  };
+  socket.onmessage = (msg) => {
+    const data = JSON.parse(msg.data);
+    if (data.type === 'event') {
+      handleEvent(data.event);
+    } else if (data.type === 'ack' && pending.has(data.id)) {
+      const p = pending.get(data.id);
+      pending.delete(data.id);
+      if (data.ok) p.resolve(data.result);
+      else p.reject(new Error(data.error && (data.error.message || data.error)));
+    }
+  };
+  socket.onclose = () => {
+    $('#conn').textContent = 'offline';
+    $('#conn').className = 'badge offline';
+    pending.forEach((p) => p.reject(new Error('connection lost')));
+    pending.clear();
+    if (token) setTimeout(connect, 3000);
+  };
+}
+
+function describeAction(action) {
+  if (action.type === 'set') return `${action.device} ${action.state}`;
+  if (action.type === 'delay') return `wait ${action.delay}`;
+  return action.type;
+}
+
+// schedules คือ rule ที่ trigger เป็น time
+async function loadSchedules() {
+  const list = $('#schedules');
+  const message = $('#schedules-message');
+  message.className = 'message';
+  let rules;
+  try {
+    rules = await api('GET', '/rules');
+  } catch (err) {
+    list.replaceChildren();
+    message.textContent = err.message;
+    message.className = 'message error';
+    return;
+  }
+  const schedules = (rules || [])
+    .filter((rule) => rule.trigger.type === 'time')
+    .sort((a, b) => a.trigger.at.localeCompare(b.trigger.at));
+  message.textContent = schedules.length ? '' : 'no schedules';
+  list.replaceChildren(...schedules.map(renderSchedule));
+}
+
+function renderSchedule(rule) {
+  const li = document.createElement('li');
+  li.className = rule.enabled ? '' : 'disabled';
+  const time = document.createElement('time');
+  time.textContent = rule.trigger.at;
+  const summary = document.createElement('span');
+  summary.className = 'summary';
+  summary.textContent = `${rule.name}: ${rule.actions.map(describeAction).join(', ')}`;
+  const toggle = document.createElement('input');
+  toggle.type = 'checkbox';
+  toggle.checked = rule.enabled;
+  toggle.title = 'enabled';
+  toggle.addEventListener('change', async () => {
+    toggle.disabled = true;
+    try {
+      await api('PUT', `/rules/${rule.id}`, { ...rule, enabled: toggle.checked });
+    } catch (err) {
+      $('#schedules-message').textContent = err.message;
+      $('#schedules-message').className = 'message error';
+    }
+    loadSchedules();
+  });
+  li.append(time, summary, toggle);
+  return li;
+}
+
+async function start() {
+  let me;
+  try {
+    me = await api('GET', '/auth/me');
+  } catch (_) {
+    showLogin();
+    return;
+  }
+  $('#who').textContent = me.name;
+  $('#login-view').hidden = true;
+  $('#panel-view').hidden = false;
+  $('#logout').hidden = false;
+
+  cards.clear();
+  $('#lights').replaceChildren();
+  $('#valves').replaceChildren();
+  $('#history').replaceChildren();
+
+  const devices = await api('GET', '/devices');
+  devices.forEach(renderCard);
+
+  const recent = await api('GET', `/events/recent?limit=${HISTORY_LIMIT}`);
+  recent.forEach(addHistory);
+
+  connect();
+  loadSchedules();
+
+  // สถานะเริ่มต้นดึงผ่าน MQTT ช้าหน่อย ไม่ต้องรอ
+  api('GET', '/light/lights')
+    .then((lights) => (lights || []).forEach((s) => applyStatus(s.id, s)))
+    .catch((err) => console.warn('lights', err));
+  devices.filter((d) => d.type === 'valve').forEach((d) => {
+    api('GET', `/valve/${encodeURIComponent(d.id)}`)
+      .then((s) => applyStatus(d.id, s))
+      .catch((err) => console.warn('valve', err));
+  });
+}
+
+if (token) start(); else showLogin();
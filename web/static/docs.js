@@ -0,0 +1,81 @@
+'use strict';
+
+// อ่าน /openapi.json แล้ว render เองแบบง่ายๆ ไม่ต้องพึ่ง CDN
+const el = (tag, attrs = {}, ...children) => {
+  const node = document.createElement(tag);
+  Object.entries(attrs).forEach(([k, v]) => { node[k] = v; });
+  children.flat().forEach((c) => node.append(c));
+  return node;
+};
+
+function resolve(spec, schema) {
+  if (schema && schema.$ref) {
+    const name = schema.$ref.split('/').pop();
+    return { name, schema: spec.components.schemas[name] };
+  }
+  return { name: null, schema };
+}
+
+function schemaText(spec, schema, depth = 0) {
+  const { name, schema: s } = resolve(spec, schema);
+  if (!s) return 'any';
+  if (depth > 2) return name || s.type || 'object';
+  if (s.type === 'array') return `${schemaText(spec, s.items, depth + 1)}[]`;
+  if (s.enum) return s.enum.join(' | ');
+  if (s.properties) {
+    const fields = Object.entries(s.properties)
+      .map(([k, v]) => `${'  '.repeat(depth + 1)}${k}: ${schemaText(spec, v, depth + 1)}`)
+      .join('\n');
+    return `${name ? name + ' ' : ''}{\n${fields}\n${'  '.repeat(depth)}}`;
+  }
+  return name || s.type || 'any';
+}
+
+function renderOperation(spec, path, method, op) {
+  const details = el('details', { className: 'card op' },
+    el('summary', {}, el('strong', {}, method.toUpperCase()), ' ', el('code', {}, path), ' — ', op.summary || ''));
+  if (op.description) details.append(el('p', {}, op.description));
+  if (op.security && op.security.length === 0) details.append(el('p', { className: 'message' }, 'No authentication required'));
+
+  if (op.parameters && op.parameters.length) {
+    details.append(el('h4', {}, 'Parameters'));
+    details.append(el('ul', {}, op.parameters.map((p) => el('li', {},
+      el('code', {}, p.name), ` (${p.in}${p.required ? ', required' : ''}) `,
+      schemaText(spec, p.schema), p.description ? ` — ${p.description}` : ''))));
+  }
+
+  if (op.requestBody) {
+    const content = op.requestBody.content['application/json'];
+    details.append(el('h4', {}, 'Request body'), el('pre', {}, schemaText(spec, content.schema)));
+  }
+
+  details.append(el('h4', {}, 'Responses'));
+  Object.entries(op.responses).forEach(([code, res]) => {
+    details.append(el('p', {}, el('strong', {}, code), ' ', res.description));
+    Object.entries(res.content || {}).forEach(([type, media]) => {
+      details.append(el('pre', {}, `${type}\n${schemaText(spec, media.schema)}`));
+    });
+  });
+  return details;
+}
+
+fetch('/openapi.json')
+  .then((res) => res.json())
+  .then((spec) => {
+    document.title = spec.info.title;
+    document.getElementById('title').textContent = `${spec.info.title} ${spec.info.version}`;
+    document.getElementById('description').textContent = spec.info.description || '';
+    const root = document.getElementById('operations');
+    const byTag = new Map();
+    Object.entries(spec.paths).forEach(([path, ops]) => {
+      Object.entries(ops).forEach(([method, op]) => {
+        const tag = (op.tags && op.tags[0]) || 'other';
+        if (!byTag.has(tag)) byTag.set(tag, []);
+        byTag.get(tag).push(renderOperation(spec, path, method, op));
+      });
+    });
+    byTag.forEach((ops, tag) => root.append(el('section', {}, el('h2', {}, tag), ops)));
+  })
+  .catch((err) => {
+    document.getElementById('operations').textContent = `Failed to load spec: ${err}`;
+  });
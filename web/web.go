@@ -0,0 +1,20 @@
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// static ถูกฝังไว้ใน binary ใช้งานในวง LAN ได้โดยไม่ต้องออกเน็ต
+//
+//go:embed static
+var static embed.FS
+
+func Handler() http.Handler {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}